@@ -31,16 +31,29 @@ func NewCmdEval() *cobra.Command {
 	}
 
 	cmd.Flags().BoolVarP(&opt.optJSON, "json", "j", opt.optJSON, "output format JSON (default: YAML)")
+	cmd.Flags().BoolVar(&opt.optJSONArray, "json-array", opt.optJSONArray, "output format JSON, always an array of documents (also if only one)")
+	cmd.Flags().BoolVar(&opt.optNDJSON, "ndjson", opt.optNDJSON, "output format newline delimited JSON, one document per line")
+	cmd.Flags().BoolVar(&opt.sortKeys, "sort-keys", opt.sortKeys, "sort object keys alphabetically (default: as written)")
+	cmd.Flags().BoolVar(&opt.complexObject, "complex-object", opt.complexObject, "output complex numbers as {re, im} objects (default: strings like \"1+2i\")")
 	cmd.Flags().StringSliceVar(&opt.setValues, "set", []string{}, "key=value pairs (take precedence over -values)")
 	cmd.Flags().StringSliceVarP(&opt.values, "values", "f", []string{}, "specify values in a YAML or JSON files")
+	cmd.Flags().StringVarP(&opt.input, "input", "i", "", "evaluate a file (the files it imports are relative to it)")
+	cmd.Flags().IntVar(&opt.maxErrors, "max-errors", 10, "maximum number of syntax errors to report (0 for all)")
 
 	return cmd
 }
 
 type evalCmd struct {
-	optJSON   bool
-	setValues []string
-	values    []string
+	optJSON      bool
+	optJSONArray bool
+	optNDJSON    bool
+	sortKeys     bool
+	setValues    []string
+	values       []string
+	input        string
+
+	complexObject bool
+	maxErrors     int
 }
 
 func (r *evalCmd) run(cmd *cobra.Command, args []string) error {
@@ -52,20 +65,62 @@ func (r *evalCmd) run(cmd *cobra.Command, args []string) error {
 
 	res, err := r.parseArgsOrStdIn(args, ds)
 	if err != nil {
-		return err
+		return limitErrors(err, r.maxErrors)
 	}
 
-	e := evaluator.Evaluator{JSON: r.optJSON}
-	return e.Eval(res)
+	e := evaluator.Evaluator{
+		JSON:      r.optJSON,
+		JSONArray: r.optJSONArray,
+		NDJSON:    r.optNDJSON,
+		SortKeys:  r.sortKeys,
+
+		ComplexObject: r.complexObject,
+	}
+	if err := e.Eval(res); err != nil {
+		return evalError{err}
+	}
+	return nil
 }
 
 func (r *evalCmd) parseArgsOrStdIn(args []string, data map[string]interface{}) ([]parser.Generator, error) {
+	if r.input != "" {
+		return parser.ParseFile(r.input, data)
+	}
 	if len(args) == 0 {
 		return parser.ParseString(stdin.Input(), data)
 	}
 	return parser.ParseString(strings.Join(args, " "), data)
 }
 
+// evalError is an error writing the documents
+// (es. a value that cannot be encoded).
+type evalError struct {
+	error
+}
+
+func (evalError) Syntax() bool {
+	return false
+}
+
+// tooManyErrors are the first errors of a list too long.
+type tooManyErrors struct {
+	parser.ErrorList
+	more int // the errors not reported
+}
+
+func (e tooManyErrors) Error() string {
+	return fmt.Sprintf("%s\n... and %d more errors", e.ErrorList.Error(), e.more)
+}
+
+// limitErrors returns err with at most max syntax errors (all if max <= 0).
+func limitErrors(err error, max int) error {
+	list, ok := err.(parser.ErrorList)
+	if !ok || max <= 0 || len(list) <= max {
+		return err
+	}
+	return tooManyErrors{ErrorList: list[:max], more: len(list) - max}
+}
+
 func (r *evalCmd) examples() string {
 	var buf bytes.Buffer
 	w := io.Writer(&buf)
@@ -107,26 +162,31 @@ func vals(valueFiles []string, values []string) (map[string]interface{}, error)
 		if err := yaml.Unmarshal(bytes, &currentMap); err != nil {
 			return map[string]interface{}{}, fmt.Errorf("failed to parse %s: %s", filePath, err)
 		}
-		// Merge with the previous map
-		base = mergeValues(base, currentMap)
+		// Merge with the previous map (yaml decodes the nested maps
+		// as map[interface{}]interface{}, like --set they must have
+		// string keys to be merged)
+		base = mergeValues(base, stringKeys(currentMap).(map[string]interface{}))
 	}
 
 	// User specified a value via --set
 	for _, value := range values {
-		if err := strvals.ParseInto(value, base); err != nil {
+		currentMap, err := strvals.Parse(value)
+		if err != nil {
 			return map[string]interface{}{}, fmt.Errorf("failed parsing --set data: %s", err)
 		}
+		// Merge with the previous map (a null value removes the key)
+		base = mergeValues(base, currentMap)
 	}
 
 	return base, nil
 }
 
-// Merges source and destination map, preferring values from the source map
+// Merges source and destination map, preferring values from the source map;
+// a null value in the source map deletes the key from the destination map
 func mergeValues(dest map[string]interface{}, src map[string]interface{}) map[string]interface{} {
 	for k, v := range src {
-		// If the key doesn't exist already, then just set the key to that value
-		if _, exists := dest[k]; !exists {
-			dest[k] = v
+		if v == nil {
+			delete(dest, k)
 			continue
 		}
 		nextMap, ok := v.(map[string]interface{})
@@ -135,11 +195,11 @@ func mergeValues(dest map[string]interface{}, src map[string]interface{}) map[st
 			dest[k] = v
 			continue
 		}
-		// Edge case: If the key exists in the destination, but isn't a map
+		// If the key doesn't exist already or it isn't a map, prefer
+		// the source map (without its null values)
 		destMap, isMap := dest[k].(map[string]interface{})
-		// If the source map has a map for this key, prefer it
 		if !isMap {
-			dest[k] = v
+			dest[k] = mergeValues(map[string]interface{}{}, nextMap)
 			continue
 		}
 		// If we got to this point, it is a map in both, so merge them
@@ -147,3 +207,30 @@ func mergeValues(dest map[string]interface{}, src map[string]interface{}) map[st
 	}
 	return dest
 }
+
+// stringKeys converts the maps decoded by yaml (map[interface{}]interface{})
+// into maps with string keys, also inside the lists.
+func stringKeys(value interface{}) interface{} {
+	switch v := value.(type) {
+	case map[interface{}]interface{}:
+		res := make(map[string]interface{}, len(v))
+		for k, el := range v {
+			res[fmt.Sprintf("%v", k)] = stringKeys(el)
+		}
+		return res
+	case map[string]interface{}:
+		res := make(map[string]interface{}, len(v))
+		for k, el := range v {
+			res[k] = stringKeys(el)
+		}
+		return res
+	case []interface{}:
+		res := make([]interface{}, len(v))
+		for i, el := range v {
+			res[i] = stringKeys(el)
+		}
+		return res
+	default:
+		return value
+	}
+}
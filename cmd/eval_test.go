@@ -0,0 +1,74 @@
+package cmd
+
+import (
+	"errors"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/stretchr/testify/require"
+)
+
+func TestValsFileAndSet(t *testing.T) {
+	file := filepath.Join(t.TempDir(), "vals.yaml")
+	require.NoError(t, os.WriteFile(file, []byte("labels:\n  a: 1\n  c: 3\nb:\n  c: 1\n  d: 2\nlist:\n- x: 1\n"), 0o644))
+
+	res, err := vals([]string{file}, []string{"labels.a=null", "b.c=5", "e.f=1,e.g=null"})
+	require.NoError(t, err)
+
+	expected := map[string]interface{}{
+		"labels": map[string]interface{}{"c": 3},
+		"b":      map[string]interface{}{"c": int64(5), "d": 2},
+		"e":      map[string]interface{}{"f": int64(1)},
+		"list":   []interface{}{map[string]interface{}{"x": 1}},
+	}
+	require.Equal(t, expected, res)
+}
+
+func TestEvalErrors(t *testing.T) {
+	testCases := []struct {
+		args     []string
+		message  string
+		exitCode int
+	}{
+		{
+			args:     []string{"--max-errors", "2", "a = ] b = ] c = ] d = ]"},
+			message:  "\n... and 2 more errors",
+			exitCode: 2,
+		},
+		{
+			args:     []string{"--max-errors", "0", "a = ] b = ] c = ]"},
+			message:  "1:17: unexpected input",
+			exitCode: 2,
+		},
+		{
+			args:     []string{`a = (fail "x")`},
+			message:  `function "fail" not defined`,
+			exitCode: 3,
+		},
+		{
+			args:     []string{`a = [1] a[3] = 2`},
+			message:  "index out of range",
+			exitCode: 3,
+		},
+		{
+			args:     []string{"-i", "missing.yo"},
+			message:  "no such file or directory",
+			exitCode: 1,
+		},
+	}
+
+	for _, cas := range testCases {
+		cmd := NewCmdEval()
+		cmd.SetArgs(cas.args)
+		cmd.SilenceUsage = true
+		cmd.SilenceErrors = true
+
+		err := cmd.Execute()
+		require.Error(t, err, cas.args)
+		require.Contains(t, err.Error(), cas.message, cas.args)
+		require.Equal(t, cas.exitCode, ExitCode(err), cas.args)
+	}
+
+	require.Equal(t, 3, ExitCode(evalError{errors.New("json: unsupported type")}))
+}
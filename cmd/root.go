@@ -1,8 +1,10 @@
 package cmd
 
 import (
+	"errors"
 	"fmt"
 
+	"github.com/lucasepe/yo/internal/parser"
 	"github.com/spf13/cobra"
 )
 
@@ -35,3 +37,16 @@ func Run() *cobra.Command {
 
 	return cmd
 }
+
+// ExitCode returns the exit status for err: 2 for the syntax
+// errors, 3 for the evaluation errors and 1 for all the others.
+func ExitCode(err error) int {
+	var perr parser.Error
+	if errors.As(err, &perr) {
+		if perr.Syntax() {
+			return 2
+		}
+		return 3
+	}
+	return 1
+}
@@ -6,6 +6,8 @@
 // Package cast provides easy and safe casting in Go.
 package cast
 
+import "time"
+
 // ToBool casts an interface to a bool type.
 func ToBool(i interface{}) bool {
 	v, _ := ToBoolE(i)
@@ -89,3 +91,9 @@ func ToString(i interface{}) string {
 	v, _ := ToStringE(i)
 	return v
 }
+
+// ToTime casts an interface to a time.Time type.
+func ToTime(i interface{}) time.Time {
+	v, _ := ToTimeE(i)
+	return v
+}
@@ -9,6 +9,7 @@ import (
 	"fmt"
 	"html/template"
 	"testing"
+	"time"
 
 	"github.com/stretchr/testify/assert"
 )
@@ -674,3 +675,43 @@ func TestErrorToString(t *testing.T) {
 	x.val = "bar"
 	assert.Equal(t, "bar", ToString(x))
 }
+
+func TestToTimeE(t *testing.T) {
+	tests := []struct {
+		input  interface{}
+		expect time.Time
+		iserr  bool
+	}{
+		{"2016-03-06 15:28:01", time.Date(2016, 3, 6, 15, 28, 1, 0, time.UTC), false},
+		{"2016-03-06T15:28:01Z", time.Date(2016, 3, 6, 15, 28, 1, 0, time.UTC), false},
+		{"2016-03-06T15:28:01", time.Date(2016, 3, 6, 15, 28, 1, 0, time.UTC), false},
+		{"2016-03-06", time.Date(2016, 3, 6, 0, 0, 0, 0, time.UTC), false},
+		{"06 Mar 2016", time.Date(2016, 3, 6, 0, 0, 0, 0, time.UTC), false},
+		{"Sun, 06 Mar 2016 15:28:01 UTC", time.Date(2016, 3, 6, 15, 28, 1, 0, time.UTC), false},
+		{int64(1457278081), time.Unix(1457278081, 0), false},
+		{time.Date(2016, 3, 6, 15, 28, 1, 0, time.UTC), time.Date(2016, 3, 6, 15, 28, 1, 0, time.UTC), false},
+		// errors
+		{"2016-13-06", time.Time{}, true},
+		{"not a date", time.Time{}, true},
+		{3.14, time.Time{}, true},
+		{testing.T{}, time.Time{}, true},
+	}
+
+	for i, test := range tests {
+		errmsg := fmt.Sprintf("i = %d", i) // assert helper message
+
+		v, err := ToTimeE(test.input)
+		if test.iserr {
+			assert.Error(t, err, errmsg)
+			continue
+		}
+
+		assert.NoError(t, err, errmsg)
+		assert.True(t, test.expect.Equal(v), errmsg)
+		assert.Equal(t, time.UTC, v.Location(), errmsg)
+
+		// Non-E test
+		v = ToTime(test.input)
+		assert.True(t, test.expect.Equal(v), errmsg)
+	}
+}
@@ -11,6 +11,7 @@ import (
 	"html/template"
 	"reflect"
 	"strconv"
+	"time"
 )
 
 var errNegativeNotAllowed = errors.New("unable to cast negative value")
@@ -795,3 +796,49 @@ func ToStringE(i interface{}) (string, error) {
 		return "", fmt.Errorf("unable to cast %#v of type %T to string", i, i)
 	}
 }
+
+// timeFormats are the layouts tried by ToTimeE, in order.
+var timeFormats = []string{
+	time.RFC3339,
+	"2006-01-02T15:04:05", // iso8601 without timezone
+	time.RFC1123Z,
+	time.RFC1123,
+	time.RFC822Z,
+	time.RFC822,
+	time.RFC850,
+	time.ANSIC,
+	time.UnixDate,
+	time.RubyDate,
+	"2006-01-02 15:04:05.999999999 -0700 MST", // Time.String()
+	"2006-01-02 15:04:05Z07:00",
+	"2006-01-02 15:04:05",
+	"2006-01-02",
+	"02 Jan 2006",
+}
+
+// ToTimeE casts an interface to a time.Time type;
+// numbers are the seconds since the unix epoch (in UTC,
+// like the dates without a zone).
+func ToTimeE(i interface{}) (time.Time, error) {
+	i = indirect(i)
+
+	switch v := i.(type) {
+	case time.Time:
+		return v, nil
+	case string:
+		for _, layout := range timeFormats {
+			if t, err := time.Parse(layout, v); err == nil {
+				return t, nil
+			}
+		}
+		return time.Time{}, fmt.Errorf("unable to parse date: %s", v)
+	case int, int64, int32, int16, int8, uint, uint64, uint32, uint16, uint8:
+		s, err := ToInt64E(v)
+		if err != nil {
+			return time.Time{}, err
+		}
+		return time.Unix(s, 0).UTC(), nil
+	default:
+		return time.Time{}, fmt.Errorf("unable to cast %#v of type %T to time.Time", i, i)
+	}
+}
@@ -3,8 +3,13 @@ package evaluator
 import (
 	"bytes"
 	"encoding/json"
+	"fmt"
 	"io"
+	"math/big"
 	"os"
+	"sort"
+	"strconv"
+	"strings"
 
 	"github.com/lucasepe/yo/internal/parser"
 	"gopkg.in/yaml.v2"
@@ -12,26 +17,144 @@ import (
 
 type Evaluator struct {
 	JSON bool
+	// JSONArray writes the documents as a JSON array even if
+	// there is only one (with JSON a single document is an object).
+	JSONArray bool
+	NDJSON    bool
+	SortKeys  bool
+	// ComplexObject writes the complex numbers as {re, im}
+	// objects instead of strings (es. "1+2i").
+	ComplexObject bool
 }
 
 func (r *Evaluator) Eval(gens []parser.Generator) error {
-	for _, g := range gens {
-		if r.JSON {
-			if err := toJSON(os.Stdout, g); err != nil {
-				return err
-			}
-		} else {
-			if err := toYAML(os.Stdout, g); err != nil {
+	return r.eval(os.Stdout, gens)
+}
+
+func (r *Evaluator) eval(w io.Writer, gens []parser.Generator) error {
+	docs := make([]parser.Any, len(gens))
+	for i, g := range gens {
+		docs[i] = complexValues(g.Get(), r.ComplexObject)
+		if r.SortKeys {
+			docs[i] = sortKeys(docs[i])
+		}
+	}
+
+	switch {
+	case r.NDJSON:
+		return toNDJSON(w, docs)
+	case r.JSONArray:
+		return toJSON(w, docs)
+	case r.JSON && len(docs) == 1:
+		return toJSON(w, docs[0])
+	case r.JSON:
+		// many documents: a single valid JSON array
+		return toJSON(w, docs)
+	}
+
+	for _, v := range docs {
+		if err := yamlNumbers(v); err != nil {
+			return err
+		}
+	}
+
+	for i, v := range docs {
+		if i > 0 {
+			if _, err := io.WriteString(w, "---\n"); err != nil {
 				return err
 			}
 		}
+
+		if err := toYAML(w, v); err != nil {
+			return err
+		}
+	}
+
+	return nil
+}
+
+// sortKeys returns a copy of v where the fields of
+// all the objects are sorted alphabetically.
+func sortKeys(v parser.Any) parser.Any {
+	switch vt := v.(type) {
+	case parser.Object:
+		res := make(parser.Object, len(vt))
+		for i, f := range vt {
+			res[i] = parser.Field{Key: f.Key, Value: sortKeys(f.Value)}
+		}
+		sort.SliceStable(res, func(i, j int) bool {
+			return res[i].Key < res[j].Key
+		})
+		return res
+	case []parser.Any:
+		res := make([]parser.Any, len(vt))
+		for i, el := range vt {
+			res[i] = sortKeys(el)
+		}
+		return res
+	default:
+		return v
 	}
+}
 
+// yamlNumbers returns an error if v has a number out of the 64 bits
+// range: JSON writes all its digits, but the YAML encoder would write
+// it as a string (silently changing its type).
+func yamlNumbers(v parser.Any) error {
+	switch vt := v.(type) {
+	case parser.Object:
+		for _, f := range vt {
+			if err := yamlNumbers(f.Value); err != nil {
+				return fmt.Errorf("%s: %w", f.Key, err)
+			}
+		}
+	case []parser.Any:
+		for i, el := range vt {
+			if err := yamlNumbers(el); err != nil {
+				return fmt.Errorf("[%d]: %w", i, err)
+			}
+		}
+	case *big.Int, parser.Decimal:
+		return fmt.Errorf("the number %v is out of the 64 bits range: YAML cannot represent it, use JSON", vt)
+	}
 	return nil
 }
 
-func toYAML(w io.Writer, g parser.Generator) (err error) {
-	dat, err := yaml.Marshal(g.Get())
+// complexValues returns a copy of v where the complex numbers,
+// that YAML and JSON cannot represent, are strings (es. "1+2i")
+// or {re, im} objects.
+func complexValues(v parser.Any, asObject bool) parser.Any {
+	switch vt := v.(type) {
+	case parser.Object:
+		res := make(parser.Object, len(vt))
+		for i, f := range vt {
+			res[i] = parser.Field{Key: f.Key, Value: complexValues(f.Value, asObject)}
+		}
+		return res
+	case []parser.Any:
+		res := make([]parser.Any, len(vt))
+		for i, el := range vt {
+			res[i] = complexValues(el, asObject)
+		}
+		return res
+	case complex64:
+		return complexValues(complex128(vt), asObject)
+	case complex128:
+		if asObject {
+			return parser.Object{
+				{Key: "re", Value: real(vt)},
+				{Key: "im", Value: imag(vt)},
+			}
+		}
+		s := strconv.FormatComplex(vt, 'g', -1, 128)
+		return strings.TrimSuffix(strings.TrimPrefix(s, "("), ")")
+	default:
+		return v
+	}
+}
+
+func toYAML(w io.Writer, v parser.Any) (err error) {
+	dat, err := yaml.Marshal(v)
 	if err != nil {
 		return err
 	}
@@ -40,8 +163,8 @@ func toYAML(w io.Writer, g parser.Generator) (err error) {
 	return err
 }
 
-func toJSON(w io.Writer, g parser.Generator) (err error) {
-	dat, err := json.Marshal(g.Get())
+func toJSON(w io.Writer, v parser.Any) (err error) {
+	dat, err := json.Marshal(v)
 	if err != nil {
 		return err
 	}
@@ -51,6 +174,23 @@ func toJSON(w io.Writer, g parser.Generator) (err error) {
 	if err != nil {
 		return err
 	}
+	out.WriteByte('\n')
 	_, err = w.Write(out.Bytes())
 	return err
 }
+
+// toNDJSON writes each document as compact JSON on its own line.
+func toNDJSON(w io.Writer, docs []parser.Any) error {
+	for _, v := range docs {
+		dat, err := json.Marshal(v)
+		if err != nil {
+			return err
+		}
+
+		if _, err := w.Write(append(dat, '\n')); err != nil {
+			return err
+		}
+	}
+
+	return nil
+}
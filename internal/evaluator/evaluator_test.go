@@ -0,0 +1,141 @@
+package evaluator
+
+import (
+	"bytes"
+	"testing"
+
+	"github.com/lucasepe/yo/internal/parser"
+	"github.com/stretchr/testify/require"
+)
+
+func TestToYAMLLiteralBlock(t *testing.T) {
+	gens, err := parser.ParseTextLines([]string{
+		`data."nginx.conf" = <<EOF`,
+		`server {`,
+		`  listen 80;`,
+		`}`,
+		`EOF`,
+	}, nil)
+	require.NoError(t, err)
+
+	var buf bytes.Buffer
+	require.NoError(t, toYAML(&buf, gens[0].Get()))
+
+	expected := "data:\n  nginx.conf: |\n    server {\n      listen 80;\n    }\n"
+	require.Equal(t, expected, buf.String())
+}
+
+func TestSortKeys(t *testing.T) {
+	gens, err := parser.ParseString(`kind=Pod apiVersion=v1 metadata={name=web labels.app=web} items=[{b=1 a=2}]`, nil)
+	require.NoError(t, err)
+
+	var buf bytes.Buffer
+	require.NoError(t, toYAML(&buf, gens[0].Get()))
+	require.Equal(t, "kind: Pod\napiVersion: v1\nmetadata:\n  name: web\n  labels:\n    app: web\nitems:\n- b: 1\n  a: 2\n", buf.String())
+
+	buf.Reset()
+	require.NoError(t, toYAML(&buf, sortKeys(gens[0].Get())))
+	require.Equal(t, "apiVersion: v1\nitems:\n- a: 2\n  b: 1\nkind: Pod\nmetadata:\n  labels:\n    app: web\n  name: web\n", buf.String())
+}
+
+func TestEvalMultiDocument(t *testing.T) {
+	gens, err := parser.ParseString("kind=Service\n---\nkind=Deployment", nil)
+	require.NoError(t, err)
+
+	testCases := []struct {
+		eval     Evaluator
+		expected string
+	}{
+		{
+			eval:     Evaluator{},
+			expected: "kind: Service\n---\nkind: Deployment\n",
+		},
+		{
+			eval:     Evaluator{JSON: true},
+			expected: "[\n   {\n      \"kind\": \"Service\"\n   },\n   {\n      \"kind\": \"Deployment\"\n   }\n]\n",
+		},
+		{
+			eval:     Evaluator{NDJSON: true},
+			expected: "{\"kind\":\"Service\"}\n{\"kind\":\"Deployment\"}\n",
+		},
+	}
+
+	for _, cas := range testCases {
+		var buf bytes.Buffer
+		require.NoError(t, cas.eval.eval(&buf, gens))
+		require.Equal(t, cas.expected, buf.String())
+	}
+
+	var buf bytes.Buffer
+	require.NoError(t, (&Evaluator{JSON: true}).eval(&buf, gens[:1]))
+	require.Equal(t, "{\n   \"kind\": \"Service\"\n}\n", buf.String())
+
+	// the empty documents are skipped: one document is left
+	gens, err = parser.ParseString("kind=Service\n---\n", nil)
+	require.NoError(t, err)
+
+	buf.Reset()
+	require.NoError(t, (&Evaluator{JSON: true}).eval(&buf, gens))
+	require.Equal(t, "{\n   \"kind\": \"Service\"\n}\n", buf.String())
+
+	buf.Reset()
+	require.NoError(t, (&Evaluator{JSONArray: true}).eval(&buf, gens))
+	require.Equal(t, "[\n   {\n      \"kind\": \"Service\"\n   }\n]\n", buf.String())
+}
+
+func TestEvalComplexNumbers(t *testing.T) {
+	gens, err := parser.ParseString(`x=1+2i list=[-3.5i 2+1.5i]`, nil)
+	require.NoError(t, err)
+
+	testCases := []struct {
+		eval     Evaluator
+		expected string
+	}{
+		{
+			eval:     Evaluator{},
+			expected: "x: 1+2i\nlist:\n- 0-3.5i\n- 2+1.5i\n",
+		},
+		{
+			eval:     Evaluator{NDJSON: true},
+			expected: `{"x":"1+2i","list":["0-3.5i","2+1.5i"]}` + "\n",
+		},
+		{
+			eval:     Evaluator{ComplexObject: true},
+			expected: "x:\n  re: 1\n  im: 2\nlist:\n- re: 0\n  im: -3.5\n- re: 2\n  im: 1.5\n",
+		},
+		{
+			eval:     Evaluator{NDJSON: true, ComplexObject: true},
+			expected: `{"x":{"re":1,"im":2},"list":[{"re":0,"im":-3.5},{"re":2,"im":1.5}]}` + "\n",
+		},
+	}
+
+	for _, cas := range testCases {
+		var buf bytes.Buffer
+		require.NoError(t, cas.eval.eval(&buf, gens))
+		require.Equal(t, cas.expected, buf.String())
+	}
+}
+
+func TestEvalBigNumbers(t *testing.T) {
+	gens, err := parser.ParseString(`pi=3.14159265358979323846 big=123456789012345678901234567890 list=[1e400]`, nil)
+	require.NoError(t, err)
+
+	var buf bytes.Buffer
+	require.NoError(t, (&Evaluator{NDJSON: true}).eval(&buf, gens))
+	require.Equal(t, `{"pi":3.141592653589793,"big":123456789012345678901234567890,"list":[1e+400]}`+"\n", buf.String())
+
+	buf.Reset()
+	err = (&Evaluator{}).eval(&buf, gens)
+	require.EqualError(t, err, "big: the number 123456789012345678901234567890 is out of the 64 bits range: YAML cannot represent it, use JSON")
+	require.Empty(t, buf.String())
+
+	gens, err = parser.ParseString(`list=[1 1e400]`, nil)
+	require.NoError(t, err)
+	err = (&Evaluator{}).eval(&buf, gens)
+	require.EqualError(t, err, "list: [1]: the number 1e+400 is out of the 64 bits range: YAML cannot represent it, use JSON")
+
+	gens, err = parser.ParseString(`pi=3.14159265358979323846 x=0.30000000000000001`, nil)
+	require.NoError(t, err)
+	require.NoError(t, (&Evaluator{}).eval(&buf, gens))
+	require.Equal(t, "pi: 3.141592653589793\nx: 0.3\n", buf.String())
+}
@@ -0,0 +1,245 @@
+package parser
+
+import (
+	"fmt"
+	"reflect"
+	"sort"
+
+	"github.com/lucasepe/yo/internal/template"
+)
+
+// peekWord reports whether the next token is the identifier word
+// followed by one of tts (a lookahead of two tokens, so that
+// the keywords can still be used as keys, es. if=1).
+func (p *parser) peekWord(word string, tts ...tokenType) bool {
+	if !p.peek(ttIdentifier) || p.next.val != word {
+		return false
+	}
+
+	lex := *p.lexer
+	after := lex.nextToken()
+	for _, tt := range tts {
+		if after.typ == tt {
+			return true
+		}
+	}
+
+	return false
+}
+
+// conditional parses the blocks of an 'if (expr) { ... } else if (expr)
+// { ... } else { ... }' chain ('if' already consumed); the content of
+// each block is parsed by body, only the chosen one is not dead.
+func (p *parser) conditional(body func()) {
+	done := false
+	for {
+		if err := p.expect(ttExpression); err != nil {
+			panic(err)
+		}
+
+		taken := !done && template.IsTrue(p.eval(p.matched.val))
+		p.block(taken, body)
+		done = done || taken
+
+		if !p.peekWord("else", ttLeftBrace, ttIdentifier) {
+			return
+		}
+		p.advance()
+
+		if !p.peekWord("if", ttExpression) {
+			p.block(!done, body)
+			return
+		}
+		p.advance()
+	}
+}
+
+// block parses a '{ ... }' block with its own variables scope;
+// when not alive the expressions are not evaluated and the
+// variables are not set.
+func (p *parser) block(alive bool, body func()) {
+	if err := p.expect(ttLeftBrace); err != nil {
+		panic(err)
+	}
+
+	p.vars = newScope(p.vars)
+	defer func() { p.vars = p.vars.parent }()
+	defer p.open(ttRightBrace)()
+
+	if !alive {
+		dead := p.dead
+		p.dead = true
+		defer func() { p.dead = dead || len(p.errors) > 0 }()
+	}
+
+	body()
+	p.closeWith(ttRightBrace)
+}
+
+// loopItem is an element of the collection of a loop.
+type loopItem struct {
+	key   Any // the index (or the map key)
+	value Any
+}
+
+// loop parses a 'for $v in (expr) BODY' or 'for $k, $v in (expr) BODY'
+// ('for' already consumed); body is parsed again for each item of the
+// evaluated collection, with the loop variables in a new scope.
+func (p *parser) loop(body func()) {
+	names := []string{p.loopVar()}
+	if p.found(ttComma) {
+		names = append(names, p.loopVar())
+	}
+
+	if !p.peekWord("in", ttExpression) {
+		p.advance()
+		panic(syntaxError("was expecting 'in (expression)'"))
+	}
+	p.advance()
+	p.advance()
+
+	items := loopItems(p.eval(p.matched.val))
+	if len(items) == 0 {
+		// parsed only to check the syntax
+		dead := p.dead
+		p.dead = true
+		defer func() { p.dead = dead || len(p.errors) > 0 }()
+
+		p.vars = newScope(p.vars)
+		defer func() { p.vars = p.vars.parent }()
+
+		body()
+		return
+	}
+
+	start := p.mark()
+	for _, it := range items {
+		p.reset(start)
+
+		p.vars = newScope(p.vars)
+		if len(names) == 1 {
+			p.vars.set(names[0], it.value)
+		} else {
+			p.vars.set(names[0], it.key)
+			p.vars.set(names[1], it.value)
+		}
+		body()
+		p.vars = p.vars.parent
+	}
+}
+
+// loopVar parses the name of a loop variable ($name); a bare name is
+// an error, since the expressions of the body could not refer to it,
+// but the loop is still parsed to check the rest of the syntax.
+func (p *parser) loopVar() string {
+	if p.found(ttIdentifier) {
+		p.record(p.errorf(fmt.Sprintf("was expecting a loop variable (es. $item), found %q", p.matched.val), true))
+		p.dead = true
+		return p.matched.val
+	}
+	if err := p.expect(ttVariable); err != nil {
+		panic(err)
+	}
+
+	return p.matched.val
+}
+
+// loopItems returns the items of a list, a map (sorted by key)
+// or of a number n (0...n-1), like the template range action.
+func loopItems(v Any) []loopItem {
+	if v == nil {
+		return nil
+	}
+
+	res := []loopItem{}
+	switch val := reflect.ValueOf(v); val.Kind() {
+	case reflect.Slice, reflect.Array:
+		for i := 0; i < val.Len(); i++ {
+			res = append(res, loopItem{key: i, value: val.Index(i).Interface()})
+		}
+	case reflect.Map:
+		keys := val.MapKeys()
+		sort.Slice(keys, func(i, j int) bool {
+			return fmt.Sprint(keys[i]) < fmt.Sprint(keys[j])
+		})
+		for _, k := range keys {
+			res = append(res, loopItem{key: k.Interface(), value: val.MapIndex(k).Interface()})
+		}
+	case reflect.Int, reflect.Int8, reflect.Int16, reflect.Int32, reflect.Int64:
+		for i := int64(0); i < val.Int(); i++ {
+			res = append(res, loopItem{key: i, value: i})
+		}
+	default:
+		panic(fmt.Sprintf("cannot iterate over %v (%T)", v, v))
+	}
+
+	return res
+}
+
+// mark is the state of the parser at some point of the input.
+type mark struct {
+	lexer   lexer
+	matched token
+	next    token
+}
+
+// mark returns the current state, to parse again the following input.
+func (p *parser) mark() mark {
+	return mark{lexer: *p.lexer, matched: p.matched, next: p.next}
+}
+
+// reset restores the state m.
+func (p *parser) reset(m mark) {
+	*p.lexer = m.lexer
+	p.matched = m.matched
+	p.next = m.next
+}
+
+// fields parses the members of a block adding them to obj (unless dead).
+func (p *parser) fields(obj *ObjectGenerator) {
+	if p.dead {
+		// parsed only to check the syntax
+		obj = mkObjectGenerator()
+	}
+	p.members(obj)
+}
+
+// fieldsIf parses a conditional adding the fields of the chosen block to obj.
+func (p *parser) fieldsIf(obj *ObjectGenerator) {
+	p.conditional(func() {
+		p.fields(obj)
+	})
+}
+
+// fieldsFor parses a loop adding the fields of its block to obj, once per item.
+func (p *parser) fieldsFor(obj *ObjectGenerator) {
+	p.loop(func() {
+		p.block(true, func() {
+			p.fields(obj)
+		})
+	})
+}
+
+// elementsIf parses a conditional adding the elements of the chosen block to arr.
+func (p *parser) elementsIf(arr *arrayGenerator) {
+	p.conditional(func() {
+		res := arr
+		if p.dead {
+			// parsed only to check the syntax
+			res = &arrayGenerator{}
+		}
+		p.elements(res, ttRightBrace)
+	})
+}
+
+// elementsFor parses a loop adding its element to arr, once per item.
+func (p *parser) elementsFor(arr *arrayGenerator) {
+	p.loop(func() {
+		res := arr
+		if p.dead {
+			// parsed only to check the syntax
+			res = &arrayGenerator{}
+		}
+		p.item(res)
+	})
+}
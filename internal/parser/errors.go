@@ -0,0 +1,307 @@
+package parser
+
+import (
+	"fmt"
+	"strings"
+)
+
+// Error is an error of the parser: Syntax reports whether the input
+// is malformed, otherwise the evaluation of a value failed (es. an
+// expression, a type conversion or an import).
+type Error interface {
+	error
+	Syntax() bool
+}
+
+// ErrorList is returned if the input has more than one syntax error.
+type ErrorList []Error
+
+func (el ErrorList) Error() string {
+	msgs := make([]string, len(el))
+	for i, e := range el {
+		msgs[i] = e.Error()
+	}
+	return strings.Join(msgs, "\n")
+}
+
+// Syntax reports whether one of the errors is a syntax error.
+func (el ErrorList) Syntax() bool {
+	for _, e := range el {
+		if e.Syntax() {
+			return true
+		}
+	}
+	return false
+}
+
+// syntaxError is the panic value of the errors in the input text,
+// all the others are evaluation errors.
+type syntaxError string
+
+func (e syntaxError) Error() string {
+	return string(e)
+}
+
+// parseError is returned if the input cannot be successfuly parsed
+type parseError struct {
+	// The parsed file ("" for strings)
+	file string
+	// The original query
+	input string
+	// The position where the parsing fails
+	pos int
+	// The error message
+	message string
+	// The input is malformed (otherwise the evaluation failed)
+	syntax bool
+}
+
+func (e parseError) Error() string {
+	line, col, text := e.position()
+	loc := fmt.Sprintf("%d:%d", line, col)
+	if e.file != "" {
+		loc = e.file + ":" + loc
+	}
+
+	// the caret is aligned keeping the tabs before it
+	pad := strings.Map(func(r rune) rune {
+		if r == '\t' {
+			return r
+		}
+		return ' '
+	}, string([]rune(text)[:col-1]))
+
+	return fmt.Sprintf("parse error: %s: %s\n%s\n%s^", loc, e.message, strings.TrimRight(text, "\r"), pad)
+}
+
+// Syntax reports whether the input is malformed.
+func (e parseError) Syntax() bool {
+	return e.syntax
+}
+
+// position returns the line and the column (both starting
+// from 1) of the error and the text of its line.
+func (e parseError) position() (line, col int, text string) {
+	pos := e.pos
+	if pos > len(e.input) {
+		pos = len(e.input)
+	}
+
+	start := strings.LastIndexByte(e.input[:pos], '\n') + 1
+	end := strings.IndexByte(e.input[pos:], '\n')
+	if end < 0 {
+		end = len(e.input)
+	} else {
+		end += pos
+	}
+
+	line = 1 + strings.Count(e.input[:start], "\n")
+	text = e.input[start:end]
+	col = 1 + len([]rune(e.input[start:pos]))
+	return line, col, text
+}
+
+// attempt runs parse (the parsing of a member of an object or of an
+// element of an array) recovering from the syntax errors: the error is
+// recorded and the input is skipped to where the parsing can go on, to
+// report all the errors at once. Returns false if parse returns false.
+func (p *parser) attempt(inObject bool, parse func() bool) (more bool) {
+	start := p.next.pos
+	vars := p.vars
+
+	defer func() {
+		r := recover()
+		if r == nil {
+			return
+		}
+
+		switch err := r.(type) {
+		case syntaxError:
+			p.record(p.errorf(err, true))
+		case parseError:
+			if !err.syntax {
+				panic(r)
+			}
+			p.record(err)
+		case ErrorList:
+			// from an imported file
+			for _, e := range err {
+				p.record(e)
+			}
+		default:
+			panic(r)
+		}
+
+		// once the input is malformed the values are not evaluated
+		// anymore, they could fail only because of the errors
+		p.vars = vars
+		p.dead = true
+		p.synchronize(start, inObject)
+		more = true
+	}()
+
+	return parse()
+}
+
+// synchronize skips the input after a syntax error up to the end of the
+// enclosing object or array or, in objects, up to the next member (an
+// identifier or a variable) at the same level; start is where the failed
+// parse began. The closing brackets not matching any open one are skipped.
+func (p *parser) synchronize(start int, inObject bool) {
+	if p.next.pos == start {
+		p.skip()
+	}
+
+	// the content of a bracket that failed is skipped
+	braces, brackets := 0, 0
+	if p.matched.pos >= start {
+		switch p.matched.typ {
+		case ttLeftBrace:
+			braces++
+		case ttLeftBracket:
+			brackets++
+		}
+	}
+
+	for {
+		switch p.next.typ {
+		case ttEof, ttSeparator:
+			return
+		case ttLeftBrace:
+			braces++
+		case ttLeftBracket:
+			brackets++
+		case ttRightBrace:
+			if braces > 0 {
+				braces--
+			} else if p.closing(ttRightBrace) {
+				return
+			}
+		case ttRightBracket:
+			if brackets > 0 {
+				brackets--
+			} else if p.closing(ttRightBracket) {
+				return
+			}
+		case ttIdentifier, ttVariable:
+			if braces == 0 && brackets == 0 && inObject && p.atMember() {
+				return
+			}
+		}
+		p.skip()
+	}
+}
+
+// open records that an object, an array or a block is open
+// until the end token; returns the func that closes it.
+func (p *parser) open(end tokenType) func() {
+	p.closers = append(p.closers, end)
+	return func() { p.closers = p.closers[:len(p.closers)-1] }
+}
+
+// closing reports whether tt closes the innermost open object (or
+// array, or block) or one that encloses it; otherwise it is stray.
+func (p *parser) closing(tt tokenType) bool {
+	for _, end := range p.closers {
+		if end == tt {
+			return true
+		}
+	}
+	return false
+}
+
+// closeWith expects the end token of an object, an array or a block;
+// otherwise (es. the end of an enclosing one) the syntax error is
+// recorded, without consuming the token.
+func (p *parser) closeWith(end tokenType) {
+	if p.found(end) {
+		return
+	}
+
+	err := p.errorf(fmt.Sprintf("was expecting %s, found %s", end, p.next), true)
+	err.pos = p.next.pos
+	p.record(err)
+	p.dead = true
+}
+
+// unexpected returns the syntax error of the next token; a closing
+// bracket is not consumed, so that it still closes the enclosing
+// object (or array) after the recovery.
+func (p *parser) unexpected() error {
+	if p.peek(ttRightBrace, ttRightBracket) {
+		err := p.errorf("unexpected input", true)
+		err.pos = p.next.pos
+		return err
+	}
+
+	p.advance()
+	return syntaxError("unexpected input")
+}
+
+// atMember reports whether the next token begins a member
+// of an object (es. a key followed by '=' or a keyword).
+func (p *parser) atMember() bool {
+	if p.peek(ttVariable) {
+		return true
+	}
+
+	lex := *p.lexer
+	after := lex.nextToken()
+	for _, tt := range fieldTokens {
+		if after.typ == tt {
+			return true
+		}
+	}
+
+	// if (expr), import "file", for $v, for v
+	switch after.typ {
+	case ttExpression, ttString, ttVariable, ttIdentifier:
+		return true
+	}
+	return false
+}
+
+// skip moves to the next token, recording the lexer errors.
+func (p *parser) skip() {
+	if p.peek(ttEof) {
+		return
+	}
+
+	p.matched = p.next
+	if p.matched.typ == ttError {
+		p.record(p.errorf(p.matched.val, true))
+	}
+	p.next = p.lexer.nextToken()
+}
+
+// errorf returns the error at the matched token.
+func (p *parser) errorf(msg interface{}, syntax bool) parseError {
+	return parseError{
+		file:    p.file,
+		input:   p.lexer.input,
+		pos:     p.matched.pos,
+		message: fmt.Sprintf("%v", msg),
+		syntax:  syntax,
+	}
+}
+
+// record adds err to the errors found so far; only the first error
+// at a position is reported (es. in the body of a loop, parsed for
+// each item, or at a bracket that is both unexpected and unclosed).
+func (p *parser) record(err Error) {
+	for _, e := range p.errors {
+		if samePos(e, err) {
+			return
+		}
+	}
+	p.errors = append(p.errors, err)
+}
+
+func samePos(a, b Error) bool {
+	pa, ok := a.(parseError)
+	if !ok {
+		return false
+	}
+	pb, ok := b.(parseError)
+	return ok && pa.file == pb.file && pa.pos == pb.pos
+}
@@ -1,5 +1,11 @@
 package parser
 
+import (
+	"fmt"
+	"reflect"
+	"sort"
+)
+
 type Any interface{}
 
 type Generator interface {
@@ -16,6 +22,15 @@ func (vg *valueGenerator) Get() Any {
 }
 
 func (vg *valueGenerator) Merge(g Generator) Generator {
+	// A list (es. from the data source) can be patched
+	if patch, ok := g.(*arrayPatch); ok && vg.value != nil {
+		arr, ok := mkGenerator(vg.value).(*arrayGenerator)
+		if !ok {
+			patch.notArray()
+		}
+		return patch.apply(arr)
+	}
+
 	// Values cannot be merged. Return the new one
 	return g
 }
@@ -24,7 +39,74 @@ func mkValueGenerator(v Any) *valueGenerator {
 	return &valueGenerator{value: v}
 }
 
+// mkGenerator wraps an already computed value; objects, lists
+// and maps of any type (es. []int64 from seq) become generators
+// so that they can be merged again.
+func mkGenerator(v Any) Generator {
+	switch vt := v.(type) {
+	case Object:
+		res := mkObjectGenerator()
+		for _, f := range vt {
+			res.add(f.Key, mkGenerator(f.Value))
+		}
+		return res
+	case []byte:
+		return mkValueGenerator(v)
+	}
+
+	switch val := reflect.ValueOf(v); val.Kind() {
+	case reflect.Slice, reflect.Array:
+		res := &arrayGenerator{}
+		for i := 0; i < val.Len(); i++ {
+			res.add(mkGenerator(val.Index(i).Interface()))
+		}
+		return res
+	case reflect.Map:
+		keys := val.MapKeys()
+		sort.Slice(keys, func(i, j int) bool {
+			return fmt.Sprint(keys[i]) < fmt.Sprint(keys[j])
+		})
+
+		res := mkObjectGenerator()
+		for _, k := range keys {
+			res.add(fmt.Sprint(k), mkGenerator(val.MapIndex(k).Interface()))
+		}
+		return res
+	default:
+		return mkValueGenerator(v)
+	}
+}
+
+// merge returns the generator of a key defined again: the forced
+// values (':=') can be changed only by other forced values and
+// the defaults ('?=') are used only if the key is not defined.
+func merge(old, value Generator) Generator {
+	_, removed := old.(*unsetGenerator)
+
+	switch value.(type) {
+	case *overrideGenerator:
+		return value
+	case *defaultGenerator:
+		if removed {
+			return value
+		}
+		return old
+	}
+
+	if _, ok := old.(*overrideGenerator); ok {
+		return old
+	}
+
+	res := old.Merge(value)
+	if u, ok := res.(*unsetGenerator); ok && len(u.path) > 0 {
+		// removing a nested key (es. -metadata.annotations.foo)
+		res = u.from(old)
+	}
+	return res
+}
+
 type ObjectGenerator struct {
+	keys   []string
 	fields map[string]Generator
 }
 
@@ -36,30 +118,53 @@ func mkObjectGenerator() *ObjectGenerator {
 
 func (obj *ObjectGenerator) add(field string, value Generator) *ObjectGenerator {
 	if gen, ok := obj.fields[field]; ok {
-		value = gen.Merge(value)
+		value = merge(gen, value)
+		if _, ok := gen.(*unsetGenerator); ok {
+			// a key defined again after the removal is a new one
+			obj.moveLast(field)
+		}
+	} else {
+		obj.keys = append(obj.keys, field)
 	}
 	obj.fields[field] = value
 	return obj
 }
 
+// moveLast moves the key field at the end of the keys.
+func (obj *ObjectGenerator) moveLast(field string) {
+	for i, k := range obj.keys {
+		if k == field {
+			obj.keys = append(append(obj.keys[:i:i], obj.keys[i+1:]...), field)
+			return
+		}
+	}
+}
+
+// Get returns an Object with the fields in insertion order.
 func (obj *ObjectGenerator) Get() Any {
-	res := map[string]Any{}
-	for field, vg := range obj.fields {
-		res[field] = vg.Get()
+	res := make(Object, 0, len(obj.keys))
+	for _, field := range obj.keys {
+		if _, ok := obj.fields[field].(*unsetGenerator); ok {
+			continue
+		}
+		res = append(res, Field{Key: field, Value: obj.fields[field].Get()})
 	}
 	return res
 }
 
 func (obj *ObjectGenerator) Merge(g Generator) Generator {
 	switch gt := g.(type) {
+	case *arrayPatch:
+		gt.notArray()
+		return g
 	case *ObjectGenerator:
 		// Objects can be merged together
 		res := mkObjectGenerator()
-		for f, v := range obj.fields {
-			res.add(f, v)
+		for _, f := range obj.keys {
+			res.add(f, obj.fields[f])
 		}
-		for f, v := range gt.fields {
-			res.add(f, v)
+		for _, f := range gt.keys {
+			res.add(f, gt.fields[f])
 		}
 		return res
 	default:
@@ -71,6 +176,10 @@ func (obj *ObjectGenerator) Merge(g Generator) Generator {
 type arrayGenerator []Generator
 
 func (arr *arrayGenerator) Merge(g Generator) Generator {
+	if patch, ok := g.(*arrayPatch); ok {
+		return patch.apply(arr)
+	}
+
 	// arrays can' t be merged with other generators
 	return g
 }
@@ -87,3 +196,142 @@ func (arr *arrayGenerator) add(g Generator) Generator {
 	*arr = append(*arr, g)
 	return arr
 }
+
+// appendIndex is the index of an arrayEdit adding a new element.
+const appendIndex = -1
+
+type arrayEdit struct {
+	index int // the element to change (or appendIndex)
+	value Generator
+	at    parseError // where the edit is, to report its errors
+}
+
+// arrayPatch changes some elements of the array defined
+// before (es. containers[0].image=x, tags[+]=y, tags+=[z])
+// instead of replacing it.
+type arrayPatch struct {
+	name  string // the patched key, used in error messages
+	edits []arrayEdit
+}
+
+func mkArrayPatch(name string, index int, value Generator, at parseError) *arrayPatch {
+	return &arrayPatch{
+		name:  name,
+		edits: []arrayEdit{{index: index, value: value, at: at}},
+	}
+}
+
+// notArray panics because the patched key is not an array.
+func (ap *arrayPatch) notArray() {
+	err := ap.edits[0].at
+	err.message = fmt.Sprintf("%s is not an array", ap.name)
+	panic(err)
+}
+
+// Get returns the result of the patch applied to an empty array.
+func (ap *arrayPatch) Get() Any {
+	return ap.apply(&arrayGenerator{}).Get()
+}
+
+func (ap *arrayPatch) Merge(g Generator) Generator {
+	if other, ok := g.(*arrayPatch); ok {
+		// patches are applied one after the other
+		res := &arrayPatch{name: ap.name}
+		res.edits = append(res.edits, ap.edits...)
+		res.edits = append(res.edits, other.edits...)
+		return res
+	}
+
+	return g
+}
+
+// apply returns a copy of arr with all the edits applied;
+// panics if an index is out of range.
+func (ap *arrayPatch) apply(arr *arrayGenerator) *arrayGenerator {
+	res := make(arrayGenerator, len(*arr))
+	copy(res, *arr)
+
+	for _, e := range ap.edits {
+		switch {
+		case e.index == appendIndex:
+			res = append(res, e.value)
+		case e.index < len(res):
+			res[e.index] = merge(res[e.index], e.value)
+		default:
+			e.at.message = fmt.Sprintf("%s[%d]: index out of range (the array has %d elements)",
+				ap.name, e.index, len(res))
+			panic(e.at)
+		}
+	}
+
+	return &res
+}
+
+// unsetGenerator removes a key (es. -metadata.name or name=!unset).
+// It stays in the object as a tombstone, so that the key is
+// removed also from the objects merged later on.
+type unsetGenerator struct {
+	path []string // the nested key to remove, empty for the key itself
+}
+
+func mkUnsetGenerator(path ...string) *unsetGenerator {
+	return &unsetGenerator{path: path}
+}
+
+func (ug *unsetGenerator) Get() Any {
+	return nil
+}
+
+func (ug *unsetGenerator) Merge(g Generator) Generator {
+	// a key defined again after the removal is back
+	return g
+}
+
+// from returns gen without the nested key ug.path;
+// gen is returned as is if it is not an object.
+func (ug *unsetGenerator) from(gen Generator) Generator {
+	obj, ok := gen.(*ObjectGenerator)
+	if !ok {
+		return gen
+	}
+
+	return obj.Merge(mkObjectGenerator().add(ug.path[0], mkUnsetGenerator(ug.path[1:]...)))
+}
+
+// defaultGenerator is the value of a key assigned with '?=',
+// used only if the key has not been defined before.
+type defaultGenerator struct {
+	value Generator
+}
+
+func mkDefaultGenerator(value Generator) *defaultGenerator {
+	return &defaultGenerator{value: value}
+}
+
+func (dg *defaultGenerator) Get() Any {
+	return dg.value.Get()
+}
+
+func (dg *defaultGenerator) Merge(g Generator) Generator {
+	// once set, it is like any other value
+	return merge(dg.value, g)
+}
+
+// overrideGenerator is the value of a key assigned with ':=',
+// that replaces the value defined before (without merging)
+// and can be changed only by another forced value.
+type overrideGenerator struct {
+	value Generator
+}
+
+func mkOverrideGenerator(value Generator) *overrideGenerator {
+	return &overrideGenerator{value: value}
+}
+
+func (og *overrideGenerator) Get() Any {
+	return og.value.Get()
+}
+
+func (og *overrideGenerator) Merge(g Generator) Generator {
+	return merge(og, g)
+}
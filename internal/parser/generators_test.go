@@ -54,3 +54,87 @@ func TestArrayGenerator(t *testing.T) {
 		require.Equal(t, og, g.Merge(og))
 	}
 }
+
+func TestObjectGenerator(t *testing.T) {
+	g := mkObjectGenerator().
+		add("kind", mkValueGenerator("Pod")).
+		add("apiVersion", mkValueGenerator("v1")).
+		add("metadata", oneFieldObjGen("name", "web"))
+
+	other := mkObjectGenerator().
+		add("spec", oneFieldObjGen("replicas", 1)).
+		add("metadata", oneFieldObjGen("namespace", "default")).
+		add("kind", mkValueGenerator("Deployment"))
+
+	expected := Object{
+		{Key: "kind", Value: "Deployment"},
+		{Key: "apiVersion", Value: "v1"},
+		{Key: "metadata", Value: Object{
+			{Key: "name", Value: "web"},
+			{Key: "namespace", Value: "default"},
+		}},
+		{Key: "spec", Value: Object{
+			{Key: "replicas", Value: 1},
+		}},
+	}
+
+	require.Equal(t, expected, g.Merge(other).Get())
+}
+
+func TestArrayPatch(t *testing.T) {
+	g := &arrayGenerator{}
+	g.add(oneFieldObjGen("name", "web"))
+	g.add(mkValueGenerator("b"))
+
+	patch := mkArrayPatch("items", 0, oneFieldObjGen("image", "nginx"), parseError{}).
+		Merge(mkArrayPatch("items", 1, mkValueGenerator("c"), parseError{})).
+		Merge(mkArrayPatch("items", appendIndex, mkValueGenerator("d"), parseError{}))
+
+	expected := []Any{
+		Object{{Key: "name", Value: "web"}, {Key: "image", Value: "nginx"}},
+		"c",
+		"d",
+	}
+	require.Equal(t, expected, g.Merge(patch).Get())
+
+	// the original array is untouched
+	require.Equal(t, []Any{Object{{Key: "name", Value: "web"}}, "b"}, g.Get())
+
+	require.Panics(t, func() {
+		g.Merge(mkArrayPatch("items", 2, mkValueGenerator("x"), parseError{}))
+	})
+}
+
+func TestUnsetGenerator(t *testing.T) {
+	obj := mkObjectGenerator().
+		add("name", mkValueGenerator("web")).
+		add("labels", oneFieldObjGen("app", "web"))
+
+	res := obj.Merge(mkObjectGenerator().
+		add("name", mkUnsetGenerator()).
+		add("labels", mkUnsetGenerator("app")).
+		add("missing", mkUnsetGenerator("x")))
+	require.Equal(t, Object{{Key: "labels", Value: Object{}}}, res.Get())
+
+	// the removed key can be defined again
+	res = res.Merge(oneFieldObjGen("name", "api"))
+	require.Equal(t, Object{{Key: "labels", Value: Object{}}, {Key: "name", Value: "api"}}, res.Get())
+}
+
+func TestMerge(t *testing.T) {
+	one, two := mkValueGenerator(1), mkValueGenerator(2)
+
+	// defaults are used only for the undefined keys
+	require.Equal(t, one, merge(one, mkDefaultGenerator(two)))
+	require.Equal(t, 2, merge(mkUnsetGenerator(), mkDefaultGenerator(two)).Get())
+
+	// forced values can be changed only by other forced values
+	forced := mkOverrideGenerator(one)
+	require.Equal(t, forced, merge(forced, two))
+	require.Equal(t, forced, merge(forced, mkUnsetGenerator()))
+	require.Equal(t, 2, merge(forced, mkOverrideGenerator(two)).Get())
+
+	// a default, once set, is merged like any other value
+	res := merge(mkDefaultGenerator(oneFieldObjGen("a", "b")), oneFieldObjGen("c", "d"))
+	require.Equal(t, Object{{Key: "a", Value: "b"}, {Key: "c", Value: "d"}}, res.Get())
+}
@@ -0,0 +1,110 @@
+package parser
+
+import (
+	"fmt"
+	"io/ioutil"
+	"path/filepath"
+	"strings"
+)
+
+// ParseFile parses the file at path; the files it imports
+// (or includes) are relative to its directory.
+// Returns either a slice of Generators on success or else an error.
+func ParseFile(path string, data map[string]interface{}) ([]Generator, error) {
+	return parseFile(path, data, nil)
+}
+
+// parseFile parses the file at path, imported by the files
+// being parsed (used to detect the import cycles).
+func parseFile(path string, data map[string]interface{}, files []string) ([]Generator, error) {
+	abs, err := filepath.Abs(path)
+	if err != nil {
+		return nil, err
+	}
+
+	for i, f := range files {
+		if f == abs {
+			chain := append(files[i:], abs)
+			for j := range chain {
+				chain[j] = filepath.Base(chain[j])
+			}
+			return nil, fmt.Errorf("import cycle: %s", strings.Join(chain, " -> "))
+		}
+	}
+
+	src, err := ioutil.ReadFile(path)
+	if err != nil {
+		return nil, err
+	}
+
+	p := newParser(newLexer(string(src)), data)
+	p.file = path
+	p.files = append(files[:len(files):len(files)], abs)
+	return p.parse()
+}
+
+// load parses the file name, relative to the one being parsed
+// (or to the working directory).
+func (p *parser) load(name string) []Generator {
+	if !filepath.IsAbs(name) && p.file != "" {
+		name = filepath.Join(filepath.Dir(p.file), name)
+	}
+
+	res, err := parseFile(name, p.ds, p.files)
+	if err != nil {
+		panic(err)
+	}
+
+	return res
+}
+
+// importFields parses an 'import "file"' statement ('import' already
+// consumed) adding to obj the fields of the imported file.
+func (p *parser) importFields(obj *ObjectGenerator) {
+	if err := p.expect(ttString); err != nil {
+		panic(err)
+	}
+	if p.dead {
+		return
+	}
+
+	name := p.matched.val
+	for _, g := range p.load(name) {
+		src, ok := g.(*ObjectGenerator)
+		if !ok {
+			panic(fmt.Sprintf("cannot import %q: it is not an object", name))
+		}
+
+		for _, k := range src.keys {
+			obj.add(k, src.fields[k])
+		}
+	}
+}
+
+// atInclude reports whether the matched token is the word 'include'
+// glued to an expression, es. include("labels.yo").
+func (p *parser) atInclude() bool {
+	return p.matched.val == "include" && p.peek(ttExpression) &&
+		p.matched.pos+len("include") == p.next.pos
+}
+
+// include parses the expression with the name of the included
+// file and returns the value it defines.
+func (p *parser) include() Generator {
+	p.advance()
+	if p.dead {
+		return mkValueGenerator(nil)
+	}
+
+	name, ok := p.eval(p.matched.val).(string)
+	if !ok {
+		panic("include: the file name must be a string")
+	}
+
+	res := p.load(name)
+	if len(res) != 1 {
+		panic(fmt.Sprintf("cannot include %q: it defines %d documents", name, len(res)))
+	}
+
+	return res[0]
+}
@@ -2,6 +2,7 @@ package parser
 
 import (
 	"fmt"
+	"strconv"
 	"strings"
 	"unicode"
 	"unicode/utf8"
@@ -17,6 +18,8 @@ type lexer struct {
 	line      int       // 1+number of newlines seen
 	startLine int       // start line of this item
 	lastSeen  tokenType // the last seen token type
+	spaced    bool      // whether spaces precede the current item
+	newline   bool      // whether a line break precedes the current item
 }
 
 // newLexer creates a new scanner for the input string.
@@ -31,9 +34,39 @@ func newLexer(input string) *lexer {
 }
 
 func (l *lexer) nextToken() token {
+	l.spaced, l.newline = false, false
 	for {
 		l.skipSpaces()
 
+		if l.atComment() {
+			// a line comment after an assignment (es. color = #fff)
+			// would leave the value to the next line
+			at, missing := l.pos, l.lastSeen.isAssign() && !strings.HasPrefix(l.input[l.pos:], "/*")
+			if !l.skipComment() {
+				return l.errorf("unterminated block comment")
+			}
+			if missing {
+				l.start = at
+				return l.errorf("missing value: a comment follows the assignment (quote a value starting with '#')")
+			}
+			l.spaced = true
+			continue
+		}
+
+		if strings.HasPrefix(l.input[l.pos:], "<<") {
+			return l.lexHeredoc()
+		}
+
+		// a bare value starts on the line of its assignment
+		if r := l.peek(); l.lastSeen.isAssign() && !l.newline && !isStructural(r) && !isRawQuote(r) && !l.atVariable() && !l.atOperator() {
+			return l.lexBare()
+		}
+
+		if l.atSeparator() {
+			l.pos += len("---")
+			return l.emit(ttSeparator)
+		}
+
 		switch r := l.pop(); {
 		case r == eof:
 			return l.emit(ttEof)
@@ -42,22 +75,55 @@ func (l *lexer) nextToken() token {
 		case r == '}':
 			return l.emit(ttRightBrace)
 		case r == '[':
+			if l.afterKey() {
+				return l.lexIndex()
+			}
 			return l.emit(ttLeftBracket)
 		case r == ']':
 			return l.emit(ttRightBracket)
 		case r == '=':
 			return l.emit(ttAssign)
+		case r == ',':
+			return l.emit(ttComma)
+		case r == '+' && l.peek() == '=':
+			l.pop()
+			return l.emit(ttAppend)
+		case r == '?' && l.peek() == '=':
+			l.pop()
+			return l.emit(ttDefault)
+		case r == ':' && l.peek() == '=':
+			l.pop()
+			return l.emit(ttOverride)
+		case r == ':' && l.afterKey():
+			return l.emit(ttColon)
 		case r == '"':
 			return l.lexQuotedString()
+		case isRawQuote(r):
+			return l.lexRawString(r)
 		case r == '(':
 			return l.lexExpression()
+		case r == '$':
+			return l.lexVariable()
+		case r == '-' && l.atKeyStart():
+			return l.emit(ttUnset)
+		case r == '.' && strings.HasPrefix(l.input[l.pos:], ".."):
+			l.pos += len("..")
+			return l.emit(ttSpread)
+		case r == '.' && l.peek() == '.':
+			l.pop()
+			return l.emit(ttRange)
 		case r == '.':
 			x := l.peek()
-			if x < '0' || '9' < x {
+			if x < '0' || '9' < x || l.afterKey() {
 				return l.emit(ttDot)
 			}
 			fallthrough // '.' can start a number.
 		case r == '+' || r == '-' || ('0' <= r && r <= '9'):
+			if l.lastSeen == ttDot && !l.spaced {
+				// a path segment made of digits (es. ports.8080)
+				l.push()
+				return l.lexIdentifier()
+			}
 			l.push()
 			return l.lexNumber()
 		case isAlphaNumeric(r):
@@ -79,7 +145,8 @@ func (l *lexer) lexIdentifier() token {
 		default:
 			l.push()
 
-			if l.lastSeen != ttAssign && !l.atTerminator() {
+			if !l.atTerminator() {
+				l.pop() // the scan goes on after the bad character
 				return l.errorf("bad character %#U", r)
 			}
 
@@ -88,17 +155,196 @@ func (l *lexer) lexIdentifier() token {
 				return l.emit(key[word])
 			}
 
-			if l.lastSeen == ttAssign {
-				return l.emit(ttString)
-			}
-
 			return l.emit(ttIdentifier)
 		}
 	}
 }
 
+// lexIndex scans the index of an array element glued to a key
+// (es. containers[0] or containers[+]), '[' already consumed.
+func (l *lexer) lexIndex() token {
+	end := strings.IndexByte(l.input[l.pos:], ']')
+	if end < 0 {
+		return l.errorf("unterminated array index")
+	}
+
+	idx := strings.TrimSpace(l.input[l.pos : l.pos+end])
+	for stop := l.pos + end + 1; l.pos < stop; {
+		l.pop()
+	}
+
+	if _, err := strconv.Atoi(idx); idx != "+" && (err != nil || idx[0] == '-' || idx[0] == '+') {
+		return l.errorf("bad array index %q: must be a number or '+'", idx)
+	}
+
+	return l.emitV(ttIndex, idx)
+}
+
+// atKeyStart reports whether the input is at the beginning
+// of a (quoted or not) key, es. after the '-' of -metadata.name.
+func (l *lexer) atKeyStart() bool {
+	r := l.peek()
+	return r == '_' || r == '"' || r == '(' || isRawQuote(r) || unicode.IsLetter(r)
+}
+
+// lexVariable scans a variable name ($ already consumed).
+func (l *lexer) lexVariable() token {
+	for isVarChar(l.peek()) {
+		l.pop()
+	}
+
+	name := l.input[l.start+1 : l.pos]
+	if name == "" {
+		return l.errorf("bad variable name: missing identifier after '$'")
+	}
+
+	return l.emitV(ttVariable, name)
+}
+
+// atVariable reports whether the input is at a variable ('$' followed
+// by a letter or an underscore, so that es. price=$5 is a bare value).
+func (l *lexer) atVariable() bool {
+	rest := l.input[l.pos:]
+	if !strings.HasPrefix(rest, "$") {
+		return false
+	}
+
+	r, _ := utf8.DecodeRuneInString(rest[1:])
+	return r == '_' || unicode.IsLetter(r)
+}
+
+// lexBare scans an unquoted scalar value (es. nginx:1.21, 10.0.0.1,
+// http://x/y) up to the next space or structural character.
+// The value is typed only if it is a clean number, bool or null.
+func (l *lexer) lexBare() token {
+	for !isStructural(l.peek()) {
+		l.pop()
+	}
+
+	word := l.input[l.start:l.pos]
+	if typ := key[word]; typ == ttBool || typ == ttNil || typ == ttUnset {
+		return l.emit(typ)
+	}
+
+	if typ, ok := numberType(word); ok {
+		return l.emit(typ)
+	}
+
+	return l.emit(ttString)
+}
+
+// numberType reports whether word is entirely a valid number literal
+// and which kind of number token it is.
+func numberType(word string) (tokenType, bool) {
+	if !strings.ContainsAny(word, "0123456789") {
+		return ttError, false
+	}
+
+	sub := newLexer(word)
+	tok := sub.lexNumber()
+	if sub.pos != len(word) {
+		return ttError, false
+	}
+
+	var err error
+	switch tok.typ {
+	case ttNumber:
+		_, err = parseNumber(word)
+	case ttComplex:
+		_, err = parseComplex(word)
+	default:
+		return ttError, false
+	}
+
+	return tok.typ, err == nil
+}
+
+// lexHeredoc scans a block value like:
+//
+//	<<EOF
+//	...
+//	EOF
+//
+// keeping all the newlines; using '<<-' the common indentation
+// of the block lines is removed.
+func (l *lexer) lexHeredoc() token {
+	l.pos += len("<<")
+	dedent := l.accept("-")
+
+	tagStart := l.pos
+	for isAlphaNumeric(l.peek()) {
+		l.pop()
+	}
+	tag := l.input[tagStart:l.pos]
+	if tag == "" {
+		return l.errorf("missing heredoc delimiter")
+	}
+
+	for r := l.pop(); r != '\n'; r = l.pop() {
+		if r == eof || !isSpace(r) {
+			return l.errorf("heredoc delimiter %q must be followed by a newline", tag)
+		}
+	}
+
+	lines := []string{}
+	for {
+		if l.pos >= len(l.input) {
+			return l.errorf("unterminated heredoc, missing %q", tag)
+		}
+
+		end := strings.IndexByte(l.input[l.pos:], '\n')
+		if end < 0 {
+			end = len(l.input) - l.pos
+		}
+		line := l.input[l.pos : l.pos+end]
+		for stop := l.pos + end; l.pos < stop; {
+			l.pop()
+		}
+
+		if strings.TrimSpace(line) == tag {
+			break
+		}
+		lines = append(lines, strings.TrimSuffix(line, "\r"))
+		l.pop() // the newline
+	}
+
+	if dedent {
+		lines = trimIndent(lines)
+	}
+
+	val := ""
+	if len(lines) > 0 {
+		val = strings.Join(lines, "\n") + "\n"
+	}
+	return l.emitV(ttString, val)
+}
+
+// lexTripleQuotedString scans a string enclosed in triple quotes,
+// that can span multiple lines and it is kept verbatim.
+// A newline right after the opening quotes is dropped.
+func (l *lexer) lexTripleQuotedString() token {
+	l.pos += len(`""`)
+
+	end := strings.Index(l.input[l.pos:], `"""`)
+	if end < 0 {
+		return l.errorf("unterminated triple quoted string")
+	}
+
+	val := l.input[l.pos : l.pos+end]
+	for stop := l.pos + end + len(`"""`); l.pos < stop; {
+		l.pop()
+	}
+
+	val = strings.TrimPrefix(val, "\r")
+	val = strings.TrimPrefix(val, "\n")
+	return l.emitV(ttString, val)
+}
+
 // lexQuotedString scans a quoted string.
 func (l *lexer) lexQuotedString() token {
+	if strings.HasPrefix(l.input[l.pos:], `""`) {
+		return l.lexTripleQuotedString()
+	}
 Loop:
 	for {
 		switch l.pop() {
@@ -114,24 +360,76 @@ Loop:
 		}
 	}
 
-	val := l.input[l.start+1 : l.pos-1]
+	val, off, err := unquote(l.input[l.start+1 : l.pos-1])
+	if err != nil {
+		l.start += 1 + off
+		return l.errorf("%v", err)
+	}
+
 	return l.emitV(ttString, val)
 }
 
-// lexExpression scans an expression string.
+// lexRawString scans a string enclosed in single quotes or backticks;
+// escape sequences are not processed. Only backtick quoted strings
+// can span multiple lines.
+func (l *lexer) lexRawString(quote rune) token {
+	for {
+		switch r := l.pop(); {
+		case r == quote:
+			val := l.input[l.start+1 : l.pos-1]
+			return l.emitV(ttString, val)
+		case r == eof, r == '\n' && quote != '`':
+			return l.errorf("unterminated raw string")
+		}
+	}
+}
+
+// unquote interprets the Go/JSON style escape sequences (\n, \t, \",
+// \u00e9...) of the double quoted string s (without quotes).
+// On failure returns the offset of the invalid sequence.
+func unquote(s string) (string, int, error) {
+	if !strings.ContainsRune(s, '\\') {
+		return s, 0, nil
+	}
+
+	var sb strings.Builder
+	for tail := s; len(tail) > 0; {
+		val, _, rest, err := strconv.UnquoteChar(tail, '"')
+		if err != nil {
+			off := len(s) - len(tail)
+			seq := tail
+			if len(seq) > 2 {
+				seq = seq[:2]
+			}
+			return "", off, fmt.Errorf("invalid escape sequence '%s' in quoted string", seq)
+		}
+		sb.WriteRune(val)
+		tail = rest
+	}
+
+	return sb.String(), 0, nil
+}
+
+// lexExpression scans an expression string, keeping track of the
+// nested round brackets and skipping the ones inside quoted strings.
 func (l *lexer) lexExpression() token {
+	depth := 1
 Loop:
 	for {
-		switch l.pop() {
-		case '\\':
-			if r := l.pop(); r != eof && r != '\n' {
-				break
-			}
-			fallthrough
-		case eof, '\n':
+		switch r := l.pop(); r {
+		case eof:
 			return l.errorf("unterminated round bracket string")
+		case '"', '`', '\'':
+			if !l.skipQuoted(r) {
+				return l.errorf("unterminated quoted string in round brackets")
+			}
+		case '(':
+			depth++
 		case ')':
-			break Loop
+			depth--
+			if depth == 0 {
+				break Loop
+			}
 		}
 	}
 
@@ -139,6 +437,51 @@ Loop:
 	return l.emitV(ttExpression, val)
 }
 
+// skipQuoted consumes a string delimited by quote (already consumed).
+// Backslash escapes are honored, except in raw (backtick) strings.
+func (l *lexer) skipQuoted(quote rune) bool {
+	for {
+		switch r := l.pop(); {
+		case r == eof:
+			return false
+		case r == '\\' && quote != '`':
+			if l.pop() == eof {
+				return false
+			}
+		case r == quote:
+			return true
+		}
+	}
+}
+
+// atSeparator reports whether the input is at a documents
+// separator ('---' followed by a space or the end of input).
+func (l *lexer) atSeparator() bool {
+	rest := l.input[l.pos:]
+	if !strings.HasPrefix(rest, "---") {
+		return false
+	}
+
+	rest = rest[len("---"):]
+	return rest == "" || isSpace(rune(rest[0]))
+}
+
+// afterKey reports whether the current item is glued to a previous
+// token that can be a key (so that a following dot is a path separator
+// and a following '[' is an array index).
+func (l *lexer) afterKey() bool {
+	if l.spaced {
+		return false
+	}
+
+	switch l.lastSeen {
+	case ttIdentifier, ttString, ttNumber, ttIndex, ttExpression:
+		return true
+	}
+
+	return false
+}
+
 // atTerminator reports whether the input is at valid termination character to
 // appear after an identifier.
 func (l *lexer) atTerminator() bool {
@@ -148,8 +491,12 @@ func (l *lexer) atTerminator() bool {
 	}
 
 	switch r {
-	case eof, '=', '.', '[', ']', '{', '}':
+	case eof, '=', '.', '[', ']', '{', '}', '(':
 		return true
+	case ':':
+		return true
+	case '+', '?':
+		return strings.HasPrefix(l.input[l.pos+1:], "=")
 	}
 
 	return false
@@ -190,7 +537,7 @@ func (l *lexer) scanNumber() bool {
 	}
 
 	l.acceptRun(digits)
-	if l.accept(".") {
+	if !strings.HasPrefix(l.input[l.pos:], "..") && l.accept(".") {
 		l.acceptRun(digits)
 	}
 
@@ -216,10 +563,50 @@ func (l *lexer) scanNumber() bool {
 	return true
 }
 
+// atComment reports whether the input is at the beginning of a
+// line ('#' or '//') or a block ('/* */') comment. A '#' glued to
+// an assignment (es. color=#fff) is part of the value instead.
+func (l *lexer) atComment() bool {
+	if l.lastSeen.isAssign() && !l.spaced {
+		return false
+	}
+
+	rest := l.input[l.pos:]
+	return strings.HasPrefix(rest, "#") ||
+		strings.HasPrefix(rest, "//") ||
+		strings.HasPrefix(rest, "/*")
+}
+
+// skipComment eats a comment; returns false if a block comment
+// is not terminated.
+func (l *lexer) skipComment() bool {
+	if strings.HasPrefix(l.input[l.pos:], "/*") {
+		end := strings.Index(l.input[l.pos+2:], "*/")
+		if end < 0 {
+			return false
+		}
+
+		for stop := l.pos + 2 + end + 2; l.pos < stop; {
+			l.pop()
+		}
+		l.drop()
+		return true
+	}
+
+	for r := l.peek(); r != eof && r != '\n'; r = l.peek() {
+		l.pop()
+	}
+	l.drop()
+	return true
+}
+
 // skipSpaces eats all spaces.
 func (l *lexer) skipSpaces() {
 	for isSpace(l.peek()) {
-		l.pop()
+		if l.pop() == '\n' {
+			l.newline = true
+		}
+		l.spaced = true
 	}
 	l.drop()
 }
@@ -297,9 +684,12 @@ func (l *lexer) emitV(typ tokenType, v string) token {
 	return res
 }
 
-// errorf returns an error token and terminates the scan by passing
-// back a nil pointer that will be the next state, terminating l.nextItem.
+// errorf returns an error token; the scan can go on after it
+// (skipping the bad character) to find the other errors.
 func (l *lexer) errorf(format string, args ...interface{}) token {
+	if l.pos == l.start && l.peek() != eof {
+		l.pop()
+	}
 	return l.emitV(ttError, fmt.Sprintf(format, args...))
 }
 
@@ -308,6 +698,64 @@ func isSpace(r rune) bool {
 	return r == ' ' || r == '\t' || r == '\r' || r == '\n'
 }
 
+// trimIndent removes the longest common leading whitespace
+// of all the not blank lines.
+func trimIndent(lines []string) []string {
+	indent := -1
+	for _, line := range lines {
+		if strings.TrimSpace(line) == "" {
+			continue
+		}
+
+		n := len(line) - len(strings.TrimLeft(line, " \t"))
+		if indent < 0 || n < indent {
+			indent = n
+		}
+	}
+
+	res := make([]string, len(lines))
+	for i, line := range lines {
+		if strings.TrimSpace(line) != "" {
+			res[i] = line[indent:]
+		}
+	}
+
+	return res
+}
+
+// atOperator reports whether the input starts with an assignment
+// operator, that cannot begin a bare value (es. 'a == 1').
+func (l *lexer) atOperator() bool {
+	for _, op := range []string{"=", "+=", "?=", ":="} {
+		if strings.HasPrefix(l.input[l.pos:], op) {
+			return true
+		}
+	}
+	return false
+}
+
+// isStructural reports whether r is a character that can not
+// be part of a bare (unquoted) scalar value.
+func isStructural(r rune) bool {
+	switch r {
+	case eof, '{', '}', '[', ']', '(', '"':
+		return true
+	}
+
+	return isSpace(r)
+}
+
+// isRawQuote reports whether r opens a raw (not escaped) string.
+func isRawQuote(r rune) bool {
+	return r == '\'' || r == '`'
+}
+
+// isVarChar reports whether r can be part of a variable name;
+// must be a valid template variable name too.
+func isVarChar(r rune) bool {
+	return r == '_' || unicode.IsLetter(r) || unicode.IsDigit(r)
+}
+
 // isAlphaNumeric reports whether r is an alphabetic, digit, underscore or dash.
 func isAlphaNumeric(r rune) bool {
 	return r == '_' ||
@@ -1,35 +1,9 @@
 package parser
 
 import (
-	"fmt"
 	"testing"
 )
 
-// Make the types prettyprint.
-var tokenName = map[tokenType]string{
-	ttEof:   "EOF",
-	ttError: "error",
-
-	ttBool:       "bool",
-	ttComplex:    "complex",
-	ttNumber:     "number",
-	ttString:     "string",
-	ttIdentifier: "identifier",
-
-	// keywords
-	ttDot: ".",
-	ttNil: "null",
-}
-
-func (tt tokenType) String() string {
-	s := tokenName[tt]
-	if s == "" {
-		return fmt.Sprintf("token%d", int(tt))
-	}
-
-	return s
-}
-
 func mkToken(typ tokenType, text string) token {
 	return token{
 		typ: typ,
@@ -113,6 +87,330 @@ var lexTests = []lexTest{
 		mkToken(ttRightBrace, "}"),
 		tEof,
 	}},
+	{"nested rb", `day=(dateInZone "2006-01-02" (now) "UTC")`, []token{
+		mkToken(ttIdentifier, "day"),
+		mkToken(ttAssign, "="),
+		mkToken(ttExpression, `dateInZone "2006-01-02" (now) "UTC"`),
+		tEof,
+	}},
+	{"deep nested rb", `a=(upper (trim (printf "%s " "x")))`, []token{
+		mkToken(ttIdentifier, "a"),
+		mkToken(ttAssign, "="),
+		mkToken(ttExpression, `upper (trim (printf "%s " "x"))`),
+		tEof,
+	}},
+	{"quoted rb", "a=(printf \"%s)\" `(`) b=1", []token{
+		mkToken(ttIdentifier, "a"),
+		mkToken(ttAssign, "="),
+		mkToken(ttExpression, "printf \"%s)\" `(`"),
+		mkToken(ttIdentifier, "b"),
+		mkToken(ttAssign, "="),
+		mkToken(ttNumber, "1"),
+		tEof,
+	}},
+	{"quoted keys", `metadata.labels."app.kubernetes.io/name"=web ports.8080=http`, []token{
+		mkToken(ttIdentifier, "metadata"),
+		tDot,
+		mkToken(ttIdentifier, "labels"),
+		tDot,
+		mkToken(ttString, "app.kubernetes.io/name"),
+		mkToken(ttAssign, "="),
+		mkToken(ttString, "web"),
+		mkToken(ttIdentifier, "ports"),
+		tDot,
+		mkToken(ttIdentifier, "8080"),
+		mkToken(ttAssign, "="),
+		mkToken(ttString, "http"),
+		tEof,
+	}},
+	{"bare", `image=nginx:1.21 version=1.2.3 host=10.0.0.1 url=http://x/y?a=b port=-80 on=true`, []token{
+		mkToken(ttIdentifier, "image"),
+		mkToken(ttAssign, "="),
+		mkToken(ttString, "nginx:1.21"),
+		mkToken(ttIdentifier, "version"),
+		mkToken(ttAssign, "="),
+		mkToken(ttString, "1.2.3"),
+		mkToken(ttIdentifier, "host"),
+		mkToken(ttAssign, "="),
+		mkToken(ttString, "10.0.0.1"),
+		mkToken(ttIdentifier, "url"),
+		mkToken(ttAssign, "="),
+		mkToken(ttString, "http://x/y?a=b"),
+		mkToken(ttIdentifier, "port"),
+		mkToken(ttAssign, "="),
+		mkToken(ttNumber, "-80"),
+		mkToken(ttIdentifier, "on"),
+		mkToken(ttAssign, "="),
+		mkToken(ttBool, "true"),
+		tEof,
+	}},
+	{"bare in object", `a={b=1e c=v1.0}`, []token{
+		mkToken(ttIdentifier, "a"),
+		mkToken(ttAssign, "="),
+		mkToken(ttLeftBrace, "{"),
+		mkToken(ttIdentifier, "b"),
+		mkToken(ttAssign, "="),
+		mkToken(ttString, "1e"),
+		mkToken(ttIdentifier, "c"),
+		mkToken(ttAssign, "="),
+		mkToken(ttString, "v1.0"),
+		mkToken(ttRightBrace, "}"),
+		tEof,
+	}},
+	{"comments", "# header\na=1 // trailing\n/* block\n comment */ b=(printf \"#%s\" \"x\") # end", []token{
+		mkToken(ttIdentifier, "a"),
+		mkToken(ttAssign, "="),
+		mkToken(ttNumber, "1"),
+		mkToken(ttIdentifier, "b"),
+		mkToken(ttAssign, "="),
+		mkToken(ttExpression, `printf "#%s" "x"`),
+		tEof,
+	}},
+	{"hash in values", `color=#fff note="a # b" c = # no value`, []token{
+		mkToken(ttIdentifier, "color"),
+		mkToken(ttAssign, "="),
+		mkToken(ttString, "#fff"),
+		mkToken(ttIdentifier, "note"),
+		mkToken(ttAssign, "="),
+		mkToken(ttString, "a # b"),
+		mkToken(ttIdentifier, "c"),
+		mkToken(ttAssign, "="),
+		mkToken(ttError, "missing value: a comment follows the assignment (quote a value starting with '#')"),
+	}},
+	{"block comment after an assignment", "a = /* x */ 1", []token{
+		mkToken(ttIdentifier, "a"),
+		mkToken(ttAssign, "="),
+		mkToken(ttNumber, "1"),
+		tEof,
+	}},
+	{"unterminated block comment", "a=1 /* oops", []token{
+		mkToken(ttIdentifier, "a"),
+		mkToken(ttAssign, "="),
+		mkToken(ttNumber, "1"),
+		mkToken(ttError, "unterminated block comment"),
+	}},
+	{"heredoc", "conf = <<EOF\nserver {\n  listen 80;\n}\nEOF\nb=1", []token{
+		mkToken(ttIdentifier, "conf"),
+		mkToken(ttAssign, "="),
+		mkToken(ttString, "server {\n  listen 80;\n}\n"),
+		mkToken(ttIdentifier, "b"),
+		mkToken(ttAssign, "="),
+		mkToken(ttNumber, "1"),
+		tEof,
+	}},
+	{"heredoc dedent", "conf = <<-END\n    a\n\n      b\n    END", []token{
+		mkToken(ttIdentifier, "conf"),
+		mkToken(ttAssign, "="),
+		mkToken(ttString, "a\n\n  b\n"),
+		tEof,
+	}},
+	{"unterminated heredoc", "conf = <<EOF\nabc", []token{
+		mkToken(ttIdentifier, "conf"),
+		mkToken(ttAssign, "="),
+		mkToken(ttError, `unterminated heredoc, missing "EOF"`),
+	}},
+	{"triple quotes", "a=\"\"\"\nline \"1\"\nline 2\"\"\" b=\"\"", []token{
+		mkToken(ttIdentifier, "a"),
+		mkToken(ttAssign, "="),
+		mkToken(ttString, "line \"1\"\nline 2"),
+		mkToken(ttIdentifier, "b"),
+		mkToken(ttAssign, "="),
+		mkToken(ttString, ""),
+		tEof,
+	}},
+	{"escapes", `a="x\ty\n\"z\" caf\u00e9"`, []token{
+		mkToken(ttIdentifier, "a"),
+		mkToken(ttAssign, "="),
+		mkToken(ttString, "x\ty\n\"z\" café"),
+		tEof,
+	}},
+	{"raw strings", "a='C:\\dir\\n' b=`\\d+\n\\w` c=it's", []token{
+		mkToken(ttIdentifier, "a"),
+		mkToken(ttAssign, "="),
+		mkToken(ttString, `C:\dir\n`),
+		mkToken(ttIdentifier, "b"),
+		mkToken(ttAssign, "="),
+		mkToken(ttString, "\\d+\n\\w"),
+		mkToken(ttIdentifier, "c"),
+		mkToken(ttAssign, "="),
+		mkToken(ttString, "it's"),
+		tEof,
+	}},
+	{"bad escape", `a="ok\q"`, []token{
+		mkToken(ttIdentifier, "a"),
+		mkToken(ttAssign, "="),
+		mkToken(ttError, `invalid escape sequence '\q' in quoted string`),
+	}},
+	{"unterminated raw string", "a='abc\n'", []token{
+		mkToken(ttIdentifier, "a"),
+		mkToken(ttAssign, "="),
+		mkToken(ttError, "unterminated raw string"),
+	}},
+	{"separator", "---\na=1\n---\nb=---x", []token{
+		mkToken(ttSeparator, "---"),
+		mkToken(ttIdentifier, "a"),
+		mkToken(ttAssign, "="),
+		mkToken(ttNumber, "1"),
+		mkToken(ttSeparator, "---"),
+		mkToken(ttIdentifier, "b"),
+		mkToken(ttAssign, "="),
+		mkToken(ttString, "---x"),
+		tEof,
+	}},
+	{"variables", `$app=web name=$app price=$5 msg=(printf "%s" $app)`, []token{
+		mkToken(ttVariable, "app"),
+		mkToken(ttAssign, "="),
+		mkToken(ttString, "web"),
+		mkToken(ttIdentifier, "name"),
+		mkToken(ttAssign, "="),
+		mkToken(ttVariable, "app"),
+		mkToken(ttIdentifier, "price"),
+		mkToken(ttAssign, "="),
+		mkToken(ttString, "$5"),
+		mkToken(ttIdentifier, "msg"),
+		mkToken(ttAssign, "="),
+		mkToken(ttExpression, `printf "%s" $app`),
+		tEof,
+	}},
+	{"array index", `containers[0].image=nginx tags[+]=x tags += [d]`, []token{
+		mkToken(ttIdentifier, "containers"),
+		mkToken(ttIndex, "0"),
+		tDot,
+		mkToken(ttIdentifier, "image"),
+		mkToken(ttAssign, "="),
+		mkToken(ttString, "nginx"),
+		mkToken(ttIdentifier, "tags"),
+		mkToken(ttIndex, "+"),
+		mkToken(ttAssign, "="),
+		mkToken(ttString, "x"),
+		mkToken(ttIdentifier, "tags"),
+		mkToken(ttAppend, "+="),
+		mkToken(ttLeftBracket, "["),
+		mkToken(ttIdentifier, "d"),
+		mkToken(ttRightBracket, "]"),
+		tEof,
+	}},
+	{"bad array index", `tags[-1]=x`, []token{
+		mkToken(ttIdentifier, "tags"),
+		mkToken(ttError, `bad array index "-1": must be a number or '+'`),
+	}},
+	{"unset", `-metadata.name -"a b" c=!unset d="!unset" e=-1`, []token{
+		mkToken(ttUnset, "-"),
+		mkToken(ttIdentifier, "metadata"),
+		tDot,
+		mkToken(ttIdentifier, "name"),
+		mkToken(ttUnset, "-"),
+		mkToken(ttString, "a b"),
+		mkToken(ttIdentifier, "c"),
+		mkToken(ttAssign, "="),
+		mkToken(ttUnset, "!unset"),
+		mkToken(ttIdentifier, "d"),
+		mkToken(ttAssign, "="),
+		mkToken(ttString, "!unset"),
+		mkToken(ttIdentifier, "e"),
+		mkToken(ttAssign, "="),
+		mkToken(ttNumber, "-1"),
+		tEof,
+	}},
+	{"loop", `for $k, $v in (.labels) {}`, []token{
+		mkToken(ttIdentifier, "for"),
+		mkToken(ttVariable, "k"),
+		mkToken(ttComma, ","),
+		mkToken(ttVariable, "v"),
+		mkToken(ttIdentifier, "in"),
+		mkToken(ttExpression, ".labels"),
+		mkToken(ttLeftBrace, "{"),
+		mkToken(ttRightBrace, "}"),
+		tEof,
+	}},
+	{"spread", `a={...(.labels) ...$x} b=...`, []token{
+		mkToken(ttIdentifier, "a"),
+		mkToken(ttAssign, "="),
+		mkToken(ttLeftBrace, "{"),
+		mkToken(ttSpread, "..."),
+		mkToken(ttExpression, ".labels"),
+		mkToken(ttSpread, "..."),
+		mkToken(ttVariable, "x"),
+		mkToken(ttRightBrace, "}"),
+		mkToken(ttIdentifier, "b"),
+		mkToken(ttAssign, "="),
+		mkToken(ttString, "..."),
+		tEof,
+	}},
+	{"computed keys", `(.app)=1 data.(.file)[0]=x -(.app)`, []token{
+		mkToken(ttExpression, ".app"),
+		mkToken(ttAssign, "="),
+		mkToken(ttNumber, "1"),
+		mkToken(ttIdentifier, "data"),
+		tDot,
+		mkToken(ttExpression, ".file"),
+		mkToken(ttIndex, "0"),
+		mkToken(ttAssign, "="),
+		mkToken(ttString, "x"),
+		mkToken(ttUnset, "-"),
+		mkToken(ttExpression, ".app"),
+		tEof,
+	}},
+	{"double assign", `a == 1`, []token{
+		mkToken(ttIdentifier, "a"),
+		mkToken(ttAssign, "="),
+		mkToken(ttAssign, "="),
+		mkToken(ttNumber, "1"),
+		tEof,
+	}},
+	{"bare value on the next line", "a =\nb=1 c=\n  42", []token{
+		mkToken(ttIdentifier, "a"),
+		mkToken(ttAssign, "="),
+		mkToken(ttIdentifier, "b"),
+		mkToken(ttAssign, "="),
+		mkToken(ttNumber, "1"),
+		mkToken(ttIdentifier, "c"),
+		mkToken(ttAssign, "="),
+		mkToken(ttNumber, "42"),
+		tEof,
+	}},
+	{"default and override", `a?=1 b:=nginx:1.21 c ?= x`, []token{
+		mkToken(ttIdentifier, "a"),
+		mkToken(ttDefault, "?="),
+		mkToken(ttNumber, "1"),
+		mkToken(ttIdentifier, "b"),
+		mkToken(ttOverride, ":="),
+		mkToken(ttString, "nginx:1.21"),
+		mkToken(ttIdentifier, "c"),
+		mkToken(ttDefault, "?="),
+		mkToken(ttString, "x"),
+		tEof,
+	}},
+	{"type annotation", `port:int="80" b:=x:y`, []token{
+		mkToken(ttIdentifier, "port"),
+		mkToken(ttColon, ":"),
+		mkToken(ttIdentifier, "int"),
+		mkToken(ttAssign, "="),
+		mkToken(ttString, "80"),
+		mkToken(ttIdentifier, "b"),
+		mkToken(ttOverride, ":="),
+		mkToken(ttString, "x:y"),
+		tEof,
+	}},
+	{"range", `[1..5 0..20 step 5 1.5]`, []token{
+		mkToken(ttLeftBracket, "["),
+		mkToken(ttNumber, "1"),
+		mkToken(ttRange, ".."),
+		mkToken(ttNumber, "5"),
+		mkToken(ttNumber, "0"),
+		mkToken(ttRange, ".."),
+		mkToken(ttNumber, "20"),
+		mkToken(ttIdentifier, "step"),
+		mkToken(ttNumber, "5"),
+		mkToken(ttNumber, "1.5"),
+		mkToken(ttRightBracket, "]"),
+		tEof,
+	}},
+	{"unbalanced rb", `a=(upper (trim "x")`, []token{
+		mkToken(ttIdentifier, "a"),
+		mkToken(ttAssign, "="),
+		mkToken(ttError, "unterminated round bracket string"),
+	}},
 }
 
 func TestLex(t *testing.T) {
@@ -125,6 +423,27 @@ func TestLex(t *testing.T) {
 	}
 }
 
+func TestLexAfterError(t *testing.T) {
+	l := newLexer("a ) b+c = 1")
+
+	tokens := []token{}
+	for el := l.nextToken(); el.typ != ttEof; el = l.nextToken() {
+		tokens = append(tokens, el)
+	}
+
+	expected := []token{
+		mkToken(ttIdentifier, "a"),
+		mkToken(ttError, "unrecognized character: U+0029 ')'"),
+		mkToken(ttError, "bad character U+002B '+'"),
+		mkToken(ttIdentifier, "c"),
+		mkToken(ttAssign, "="),
+		mkToken(ttNumber, "1"),
+	}
+	if !equal(t, tokens, expected, false) {
+		t.Errorf("got\n\t%+v\nexpected\n\t%+v", tokens, expected)
+	}
+}
+
 // collect gathers the emitted items into a slice.
 func collect(t *lexTest) (tokens []token) {
 	l := newLexer(t.input)
@@ -0,0 +1,83 @@
+package parser
+
+import (
+	"fmt"
+	"math/big"
+	"strconv"
+	"strings"
+)
+
+// Decimal is a float literal out of the float64 range.
+type Decimal struct {
+	*big.Float
+}
+
+// String returns the shortest decimal representation of d.
+func (d Decimal) String() string {
+	return d.Text('g', -1)
+}
+
+// MarshalJSON writes d as a JSON number.
+func (d Decimal) MarshalJSON() ([]byte, error) {
+	return []byte(d.String()), nil
+}
+
+// MarshalYAML fails: the YAML encoder cannot write d as a
+// number and a string would silently change its type.
+func (d Decimal) MarshalYAML() (interface{}, error) {
+	return nil, fmt.Errorf("the number %v is out of the float64 range: YAML cannot represent it, use JSON", d)
+}
+
+// intBase returns the base to parse the integer literal value with:
+// 0 to use its prefix (0x, 0o, 0b), 10 for the decimal numbers
+// (also with leading zeros, that don't mean octal).
+func intBase(value string) int {
+	digits := strings.TrimLeft(value, "+-")
+	if len(digits) > 1 && digits[0] == '0' && ('0' <= digits[1] && digits[1] <= '9' || digits[1] == '_') {
+		return 10
+	}
+	return 0
+}
+
+// parseInt parses an integer literal as int64, uint64
+// or, if it is even bigger, as *big.Int.
+func parseInt(value string) (Any, bool) {
+	base := intBase(value)
+	if base == 10 {
+		value = strings.ReplaceAll(value, "_", "")
+	}
+
+	i, err := strconv.ParseInt(value, base, 64)
+	if err == nil {
+		return i, true
+	}
+	if err.(*strconv.NumError).Err != strconv.ErrRange {
+		return nil, false
+	}
+
+	if u, err := strconv.ParseUint(strings.TrimPrefix(value, "+"), base, 64); err == nil {
+		return u, true
+	}
+
+	res, ok := new(big.Int).SetString(value, base)
+	return res, ok
+}
+
+// parseFloat parses a float literal as float64 (rounded to the
+// nearest one, like JSON does) or, if it is out of range, as Decimal.
+func parseFloat(value string) (Any, bool) {
+	f, err := strconv.ParseFloat(value, 64)
+	if err == nil {
+		return f, true
+	}
+	if err.(*strconv.NumError).Err != strconv.ErrRange {
+		return nil, false
+	}
+
+	prec := uint(len(value))*4 + 64
+	exact, _, err := big.ParseFloat(value, 0, prec, big.ToNearestEven)
+	if err != nil {
+		return nil, false
+	}
+	return Decimal{exact}, true
+}
@@ -0,0 +1,66 @@
+package parser
+
+import (
+	"encoding/json"
+	"math/big"
+	"testing"
+
+	"github.com/stretchr/testify/require"
+	"gopkg.in/yaml.v2"
+)
+
+func TestParseNumber(t *testing.T) {
+	bigInt, _ := new(big.Int).SetString("123456789012345678901234567890", 10)
+
+	testCases := []struct {
+		input    string
+		expected Any
+	}{
+		{"42", int64(42)},
+		{"-42", int64(-42)},
+		{"0x1F", int64(31)},
+		{"0o644", int64(420)},
+		{"0b101", int64(5)},
+		{"1_000_000", int64(1000000)},
+		{"0755", int64(755)},
+		{"09123", int64(9123)},
+		{"18446744073709551615", uint64(18446744073709551615)},
+		{"123456789012345678901234567890", bigInt},
+		{"0.1", 0.1},
+		{"0.30000000000000001", 0.3},
+		{"3.14159265358979323846", 3.141592653589793},
+		{"1e-400", float64(0)},
+		{"1_000.5", 1000.5},
+		{"1e3", float64(1000)},
+		{"0x1p-2", 0.25},
+		{"2.5i", complex(0, 2.5)},
+	}
+
+	for _, cas := range testCases {
+		res, err := parseNumber(cas.input)
+		require.NoError(t, err, cas.input)
+		require.Equal(t, cas.expected, res, cas.input)
+	}
+
+	for _, input := range []string{"1e400", "-1e400"} {
+		res, err := parseNumber(input)
+		require.NoError(t, err, input)
+		require.IsType(t, Decimal{}, res, input)
+	}
+
+	_, err := parseNumber("0x")
+	require.Error(t, err)
+}
+
+func TestMarshalBigNumbers(t *testing.T) {
+	num, _ := parseNumber("123456789012345678901234567890")
+	dec, _ := parseNumber("1e400")
+	obj := Object{{Key: "big", Value: num}, {Key: "dec", Value: dec}}
+
+	res, err := json.Marshal(obj)
+	require.NoError(t, err)
+	require.Equal(t, `{"big":123456789012345678901234567890,"dec":1e+400}`, string(res))
+
+	_, err = yaml.Marshal(Object{{Key: "dec", Value: dec}})
+	require.EqualError(t, err, "the number 1e+400 is out of the float64 range: YAML cannot represent it, use JSON")
+}
@@ -0,0 +1,74 @@
+package parser
+
+import (
+	"bytes"
+	"encoding/json"
+
+	"gopkg.in/yaml.v2"
+)
+
+// Field is a single key/value pair of an Object.
+type Field struct {
+	Key   string
+	Value Any
+}
+
+// Object is the value produced by an ObjectGenerator: a list
+// of fields that keeps the insertion order once marshaled.
+type Object []Field
+
+// MarshalYAML implements the yaml.Marshaler interface.
+func (o Object) MarshalYAML() (interface{}, error) {
+	res := make(yaml.MapSlice, len(o))
+	for i, f := range o {
+		res[i] = yaml.MapItem{Key: f.Key, Value: f.Value}
+	}
+	return res, nil
+}
+
+// MarshalJSON implements the json.Marshaler interface.
+func (o Object) MarshalJSON() ([]byte, error) {
+	var buf bytes.Buffer
+	buf.WriteByte('{')
+	for i, f := range o {
+		if i > 0 {
+			buf.WriteByte(',')
+		}
+
+		key, err := json.Marshal(f.Key)
+		if err != nil {
+			return nil, err
+		}
+		buf.Write(key)
+		buf.WriteByte(':')
+
+		val, err := json.Marshal(f.Value)
+		if err != nil {
+			return nil, err
+		}
+		buf.Write(val)
+	}
+	buf.WriteByte('}')
+	return buf.Bytes(), nil
+}
+
+// plain converts the Objects contained in v into maps, so
+// that they can be used by the template expressions.
+func plain(v Any) Any {
+	switch vt := v.(type) {
+	case Object:
+		res := make(map[string]interface{}, len(vt))
+		for _, f := range vt {
+			res[f.Key] = plain(f.Value)
+		}
+		return res
+	case []Any:
+		res := make([]interface{}, len(vt))
+		for i, el := range vt {
+			res[i] = plain(el)
+		}
+		return res
+	default:
+		return v
+	}
+}
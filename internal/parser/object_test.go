@@ -0,0 +1,28 @@
+package parser
+
+import (
+	"encoding/json"
+	"testing"
+
+	"github.com/stretchr/testify/require"
+	"gopkg.in/yaml.v2"
+)
+
+func TestObjectMarshal(t *testing.T) {
+	obj := Object{
+		{Key: "zeta", Value: 1},
+		{Key: "alpha", Value: Object{
+			{Key: "b", Value: true},
+			{Key: "a", Value: []Any{"x", Object{{Key: "k", Value: "v"}}}},
+		}},
+		{Key: "empty", Value: Object{}},
+	}
+
+	dat, err := yaml.Marshal(obj)
+	require.NoError(t, err)
+	require.Equal(t, "zeta: 1\nalpha:\n  b: true\n  a:\n  - x\n  - k: v\nempty: {}\n", string(dat))
+
+	dat, err = json.Marshal(obj)
+	require.NoError(t, err)
+	require.Equal(t, `{"zeta":1,"alpha":{"b":true,"a":["x",{"k":"v"}]},"empty":{}}`, string(dat))
+}
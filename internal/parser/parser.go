@@ -2,31 +2,33 @@ package parser
 
 import (
 	"fmt"
+	"reflect"
 	"strconv"
 	"strings"
 
 	"github.com/lucasepe/yo/internal/template"
 )
 
-// parseError is returned if the input cannot be successfuly parsed
-type parseError struct {
-	// The original query
-	input string
-	// The position where the parsing fails
-	pos int
-	// The error message
-	message string
-}
+// fieldTokens are the tokens that can follow a field key.
+var fieldTokens = []tokenType{ttAssign, ttAppend, ttDefault, ttOverride, ttDot, ttIndex, ttColon}
 
-func (e parseError) Error() string {
-	return fmt.Sprintf("parse error: %s\n%s\n%s^", e.message, e.input, strings.Repeat(" ", e.pos))
-}
+// keyTokens are the tokens that can be used as a field key
+// (or as a segment of a dotted path); expressions are computed keys.
+var keyTokens = []tokenType{ttIdentifier, ttString, ttNumber, ttExpression}
 
 type parser struct {
 	lexer   *lexer
 	matched token
 	next    token
 	ds      map[string]interface{}
+	vars    *scope
+	path    []string    // path of the field being parsed
+	frames  []frame     // objects and arrays being parsed
+	dead    bool        // parsing a block that is not emitted
+	file    string      // the file being parsed ("" for strings)
+	files   []string    // the files being parsed, to detect the import cycles
+	errors  ErrorList   // the syntax errors found so far
+	closers []tokenType // the end tokens of the open objects, arrays and blocks
 }
 
 func newParser(lex *lexer, data map[string]interface{}) *parser {
@@ -34,38 +36,93 @@ func newParser(lex *lexer, data map[string]interface{}) *parser {
 		lexer: lex,
 		next:  lex.nextToken(),
 		ds:    data,
+		vars:  newScope(nil),
 	})
 }
 
 func (p *parser) parse() (gen []Generator, err error) {
 	defer func() {
 		if r := recover(); r != nil {
-			gen = nil
-			err = parseError{
-				input:   p.lexer.input,
-				pos:     p.matched.pos,
-				message: fmt.Sprintf("%v", r),
+			switch e := r.(type) {
+			case ErrorList:
+				// from an imported file
+				for _, el := range e {
+					p.record(el)
+				}
+			case Error:
+				// from an imported file
+				p.record(e)
+			case syntaxError:
+				p.record(p.errorf(e, true))
+			default:
+				p.record(p.errorf(r, false))
 			}
 		}
+
+		switch len(p.errors) {
+		case 0:
+		case 1:
+			gen, err = nil, p.errors[0]
+		default:
+			gen, err = nil, p.errors
+		}
 	}()
+
 	gen = p.run()
-	if !p.found(ttEof) {
-		p.advance()
-		panic("unexpected input")
+	for !p.found(ttEof) {
+		// es. a '}' without its '{'
+		p.attempt(true, func() bool {
+			p.advance()
+			panic(syntaxError("unexpected input"))
+		})
+		gen = append(gen, p.run()...)
+	}
+
+	if len(p.errors) > 0 {
+		return
+	}
+
+	// apply the array patches not merged with an array
+	// defined before, to report the indexes out of range
+	for _, g := range gen {
+		g.Get()
 	}
 	return
 }
 
 func (p *parser) run() []Generator {
-	p.peek()
+	res := []Generator{}
+	for {
+		res = append(res, p.document()...)
+		if !p.found(ttSeparator) {
+			break
+		}
+	}
+
+	if len(res) == 0 {
+		res = append(res, mkObjectGenerator())
+	}
+
+	return res
+}
+
+// document parses either a sequence of objects and arrays
+// or the fields of a single object; empty documents are skipped.
+func (p *parser) document() []Generator {
+	for p.found(ttVariable) {
+		p.declare(p.matched.val)
+	}
+
+	res := []Generator{}
 	if p.peek(ttLeftBrace) || p.peek(ttLeftBracket) {
-		res := []Generator{}
 		for {
 			switch {
 			case p.found(ttLeftBrace):
 				res = append(res, p.object())
 			case p.found(ttLeftBracket):
 				res = append(res, p.array())
+			case p.found(ttVariable):
+				p.declare(p.matched.val)
 			default:
 				return res
 			}
@@ -73,138 +130,491 @@ func (p *parser) run() []Generator {
 	}
 
 	objGen := mkObjectGenerator()
-	for p.found(ttIdentifier) {
-		if p.peek(ttAssign) || p.peek(ttDot) {
-			field := p.matched.val
-			value := p.field(field)
-			objGen.add(field, value)
-		}
+	p.pushFrame(objGen)
+	p.members(objGen)
+	p.popFrame()
+
+	if len(objGen.keys) > 0 {
+		res = append(res, objGen)
 	}
 
-	return []Generator{objGen}
+	return res
 }
 
 func (p *parser) object() Generator {
+	p.vars = newScope(p.vars)
+	defer func() { p.vars = p.vars.parent }()
+
 	res := mkObjectGenerator()
-	for p.found(ttIdentifier) {
-		if p.peek(ttAssign) || p.peek(ttDot) {
-			field := p.matched.val
-			value := p.field(field)
-			res.add(field, value)
+	p.pushFrame(res)
+	defer p.popFrame()
+
+	defer p.open(ttRightBrace)()
+	p.members(res)
+	p.closeWith(ttRightBrace)
+
+	return res
+}
+
+// members parses the fields (and the variables declarations)
+// of an object, until something else is found.
+func (p *parser) members(obj *ObjectGenerator) {
+	for p.attempt(true, func() bool { return p.member(obj) }) {
+	}
+}
+
+// member parses a field (or a variable declaration, an import, a
+// conditional, ...) of obj; returns false if none is found.
+func (p *parser) member(obj *ObjectGenerator) bool {
+	switch {
+	case p.peek(ttError):
+		p.advance() // panics with the lexer error
+	case p.found(ttVariable):
+		p.declare(p.matched.val)
+	case p.found(ttUnset):
+		p.unset(obj)
+	case p.found(ttSpread):
+		p.spreadFields(obj)
+	case p.peekWord("import", ttString):
+		p.advance()
+		p.importFields(obj)
+	case p.peekWord("if", ttExpression):
+		p.advance()
+		p.fieldsIf(obj)
+	case p.peekWord("for", ttVariable, ttIdentifier):
+		p.advance()
+		p.fieldsFor(obj)
+	case p.found(keyTokens...):
+		if !p.peek(fieldTokens...) {
+			// a key without a value
+			panic(syntaxError("unexpected input"))
+		}
+
+		field := p.key()
+		value := p.field(field)
+		obj.add(field, value)
+	case p.peek(ttRightBrace, ttRightBracket):
+		if p.closing(p.next.typ) {
+			return false
+		}
+		// a stray bracket, skipped by the recovery
+		panic(p.unexpected())
+	case p.peek(ttEof, ttSeparator):
+		return false
+	default:
+		panic(p.unexpected())
+	}
+
+	return true
+}
+
+// key returns the key of the matched token,
+// evaluating the computed ones (es. (printf "%s-config" .app)).
+func (p *parser) key() string {
+	if p.matched.typ != ttExpression {
+		return p.matched.val
+	}
+
+	expr := p.matched.val
+	res := p.eval(expr)
+	if res == nil {
+		if p.dead {
+			return ""
+		}
+		panic(fmt.Sprintf("the computed key (%s) is null", expr))
+	}
+
+	switch reflect.ValueOf(res).Kind() {
+	case reflect.Map, reflect.Slice, reflect.Array, reflect.Struct, reflect.Ptr, reflect.Func, reflect.Chan:
+		panic(fmt.Sprintf("the computed key (%s) must be a string, not %T", expr, res))
+	}
+
+	return fmt.Sprint(res)
+}
+
+// unset parses the key (or the dotted path) to remove from obj.
+func (p *parser) unset(obj *ObjectGenerator) {
+	if err := p.expect(keyTokens...); err != nil {
+		panic(err)
+	}
+
+	field := p.key()
+	path := []string{}
+	for p.found(ttDot) {
+		if err := p.expect(keyTokens...); err != nil {
+			panic(err)
 		}
+		path = append(path, p.key())
+	}
+
+	obj.add(field, mkUnsetGenerator(path...))
+}
+
+// spread parses the value of a '...(expr)' or '...$var' spread.
+func (p *parser) spread() Generator {
+	switch {
+	case p.found(ttExpression):
+		return mkValueGenerator(p.eval(p.matched.val))
+	case p.found(ttVariable):
+		return p.variable(p.matched.val)
+	default:
+		p.advance()
+		panic(syntaxError("was expecting an expression or a variable after '...'"))
+	}
+}
+
+// spreadFields deep merges into obj all the fields of a spread object.
+func (p *parser) spreadFields(obj *ObjectGenerator) {
+	value := p.spread().Get()
+	if value == nil {
+		return
+	}
+
+	src, ok := mkGenerator(value).(*ObjectGenerator)
+	if !ok {
+		panic(fmt.Sprintf("cannot spread %v (%T) into an object", value, value))
+	}
+
+	for _, k := range src.keys {
+		obj.add(k, src.fields[k])
+	}
+}
+
+// spreadElements adds to arr all the elements of a spread array.
+func (p *parser) spreadElements(arr *arrayGenerator) {
+	value := p.spread().Get()
+	if value == nil {
+		return
+	}
+
+	src, ok := mkGenerator(value).(*arrayGenerator)
+	if !ok {
+		panic(fmt.Sprintf("cannot spread %v (%T) into an array", value, value))
+	}
+
+	for _, el := range *src {
+		arr.add(el)
 	}
+}
 
-	if err := p.expect(ttRightBrace); err != nil {
+// declare parses the value of the variable name (that is not emitted).
+func (p *parser) declare(name string) {
+	if err := p.expect(ttAssign); err != nil {
 		panic(err)
 	}
 
-	return res
+	value := p.value()
+	if !p.dead {
+		p.vars.set(name, value.Get())
+	}
+}
+
+// variable returns a generator for the value of the variable name.
+func (p *parser) variable(name string) Generator {
+	v, ok := p.vars.lookup(name)
+	if !ok && p.dead {
+		// declared in a dead block
+		return mkValueGenerator(nil)
+	}
+	if !ok {
+		panic(fmt.Sprintf("undefined variable $%s", name))
+	}
+
+	return mkGenerator(v)
 }
 
 func (p *parser) array() Generator {
 	res := &arrayGenerator{}
+	p.pushFrame(res)
+	defer p.popFrame()
 
-	for {
-		switch {
-		case p.found(ttExpression):
-			src, err := template.ExecuteInline(p.ds, fmt.Sprintf("{{%s}}", p.matched.val))
-			if err != nil {
-				panic(err)
-			}
-			v := mkValueGenerator(string(src))
-			res.add(v)
+	defer p.open(ttRightBracket)()
+	p.elements(res, ttRightBracket)
+	p.closeWith(ttRightBracket)
 
-		case p.found(ttString):
-			//fmt.Println(" - found string", p.matched.val)
-			v := mkValueGenerator(p.matched.val)
-			res.add(v)
+	return res
+}
 
-		case p.found(ttNil):
-			return mkValueGenerator(nil)
+// elements parses the elements of arr until the end token.
+func (p *parser) elements(arr *arrayGenerator, end tokenType) {
+	for !p.peek(end) {
+		if p.found(ttEof) {
+			panic(syntaxError("unclosed array"))
+		}
+		if p.peek(ttRightBrace, ttRightBracket) && p.closing(p.next.typ) {
+			// the end of an enclosing object (or array)
+			return
+		}
 
-		case p.found(ttNumber):
-			src, err := parseNumber(p.matched.val)
-			if err != nil {
-				panic(err)
-			}
-			v := mkValueGenerator(src)
-			res.add(v)
+		p.attempt(false, func() bool {
+			p.item(arr)
+			return true
+		})
+	}
+}
 
-		case p.found(ttComplex):
-			src, err := parseComplex(p.matched.val)
-			if err != nil {
-				panic(err)
-			}
-			v := mkValueGenerator(src)
-			res.add(v)
+// item parses an element, a conditional or a loop of arr.
+func (p *parser) item(arr *arrayGenerator) {
+	switch {
+	case p.found(ttSpread):
+		p.spreadElements(arr)
+	case p.peekWord("if", ttExpression):
+		p.advance()
+		p.elementsIf(arr)
+	case p.peekWord("for", ttVariable, ttIdentifier):
+		p.advance()
+		p.elementsFor(arr)
+	default:
+		p.enter(strconv.Itoa(len(*arr)))
+		p.element(arr)
+		p.leave()
+	}
+}
 
-		case p.found(ttBool):
-			src, err := parseBool(p.matched.val)
-			if err != nil {
-				panic(err)
-			}
-			v := mkValueGenerator(src)
-			res.add(v)
-
-		case p.found(ttIdentifier):
-			if p.peek(ttAssign) || p.peek(ttDot) {
-				field := p.matched.val
-				value := p.field(field)
-				// Add 1-field obj to array
-				obj := mkObjectGenerator()
-				obj.add(field, value)
-				res.add(obj)
-			} else {
-				res.add(mkValueGenerator(p.matched.val))
-			}
-		case p.found(ttLeftBrace):
-			res.add(p.object())
-			//Add obj as array elem
-		case p.found(ttLeftBracket):
-			res.add(p.array())
-			// Add array as arr elem
-		case p.found(ttRightBracket):
-			// return, the array is complete
-			return res
-
-		case p.found(ttEof):
-			panic("unclosed array")
-		default:
-			p.advance()
-			panic("unexpected input")
+// element parses an array element and adds it to arr.
+func (p *parser) element(arr *arrayGenerator) {
+	switch {
+	case p.found(ttExpression):
+		if p.peek(fieldTokens...) {
+			// Add 1-field obj (with a computed key) to array
+			field := p.key()
+			value := p.field(field)
+			arr.add(mkObjectGenerator().add(field, value))
+		} else if p.peek(ttRange) {
+			p.rangeElements(arr)
+		} else {
+			arr.add(mkValueGenerator(p.eval(p.matched.val)))
+		}
+
+	case p.found(ttVariable):
+		if p.peek(ttRange) {
+			p.rangeElements(arr)
+		} else {
+			arr.add(p.variable(p.matched.val))
+		}
+
+	case p.found(ttString):
+		if p.peek(fieldTokens...) {
+			// Add 1-field obj (with a quoted key) to array
+			field := p.matched.val
+			value := p.field(field)
+			arr.add(mkObjectGenerator().add(field, value))
+		} else {
+			arr.add(mkValueGenerator(p.matched.val))
+		}
+
+	case p.found(ttNil):
+		arr.add(mkValueGenerator(nil))
+
+	case p.found(ttNumber):
+		if p.peek(ttRange) {
+			p.rangeElements(arr)
+			return
+		}
+		if p.peek(fieldTokens...) {
+			// Add 1-field obj (with a numeric key) to array
+			field := p.key()
+			value := p.field(field)
+			arr.add(mkObjectGenerator().add(field, value))
+			return
+		}
+		src, err := parseNumber(p.matched.val)
+		if err != nil {
+			panic(err)
+		}
+		arr.add(mkValueGenerator(src))
+
+	case p.found(ttComplex):
+		src, err := parseComplex(p.matched.val)
+		if err != nil {
+			panic(err)
+		}
+		arr.add(mkValueGenerator(src))
+
+	case p.found(ttBool):
+		src, err := parseBool(p.matched.val)
+		if err != nil {
+			panic(err)
 		}
+		arr.add(mkValueGenerator(src))
+
+	case p.found(ttIdentifier):
+		if p.atInclude() {
+			arr.add(p.include())
+			return
+		}
+		if p.peek(fieldTokens...) {
+			// Add 1-field obj to array
+			field := p.matched.val
+			value := p.field(field)
+			arr.add(mkObjectGenerator().add(field, value))
+		} else {
+			arr.add(mkValueGenerator(p.matched.val))
+		}
+
+	case p.found(ttLeftBrace):
+		// Add obj as array elem
+		arr.add(p.object())
+
+	case p.found(ttLeftBracket):
+		// Add array as arr elem
+		arr.add(p.array())
+
+	default:
+		panic(p.unexpected())
 	}
 }
 
 func (p *parser) field(field string) Generator {
+	p.enter(field)
+	defer p.leave()
+
+	if typ := p.annotation(); typ != "" {
+		switch {
+		case p.found(ttAssign):
+			return p.typed(typ, p.value())
+		case p.found(ttDefault):
+			return mkDefaultGenerator(p.typed(typ, p.value()))
+		case p.found(ttOverride):
+			return mkOverrideGenerator(p.typed(typ, p.value()))
+		default:
+			p.advance()
+			panic(syntaxError("a type annotation must be followed by '=', '?=' or ':='"))
+		}
+	}
+
 	switch {
 	case p.found(ttAssign):
 		return p.value()
+	case p.found(ttAppend):
+		return p.appendValue()
+	case p.found(ttDefault):
+		return mkDefaultGenerator(p.value())
+	case p.found(ttOverride):
+		return mkOverrideGenerator(p.value())
+	case p.found(ttIndex):
+		index := appendIndex
+		if p.matched.val != "+" {
+			index, _ = strconv.Atoi(p.matched.val)
+		}
+
+		name := strings.Join(p.path, ".")
+		at := p.errorf("", false)
+		value := p.field(p.matched.val)
+		return mkArrayPatch(name, index, value, at)
 	case p.found(ttDot):
-		//nolint:errcheck
-		p.expect(ttIdentifier)
+		if err := p.expect(keyTokens...); err != nil {
+			panic(err)
+		}
 
-		field := p.matched.val
+		field := p.key()
 		value := p.field(field)
 		return mkObjectGenerator().add(field, value)
 	case p.found(ttEof):
-		panic("unexpected end of input")
+		panic(syntaxError("unexpected end of input"))
 	default:
+		panic(p.unexpected())
+	}
+}
+
+// rangeElements parses a 'start..end' or 'start..end step n' range
+// (the start bound already matched) adding its integers to arr.
+func (p *parser) rangeElements(arr *arrayGenerator) {
+	start := p.bound()
+	p.advance()
+
+	if err := p.expect(ttNumber, ttExpression, ttVariable); err != nil {
+		panic(err)
+	}
+	end := p.bound()
+
+	step := int64(1)
+	if start > end {
+		step = -1
+	}
+	if p.peek(ttIdentifier) && p.next.val == "step" {
 		p.advance()
-		panic("unexpected input")
+		if !p.found(ttNumber, ttExpression, ttVariable) {
+			// not consumed: it can be the end of the array
+			panic(p.errorf("missing the step of the range (a number, an expression or a variable)", true))
+		}
+		step = p.bound()
+	}
+
+	if p.dead {
+		return
+	}
+
+	seq, err := template.Sequence(start, end, step)
+	if err != nil {
+		panic(err)
+	}
+	for _, n := range seq {
+		arr.add(mkValueGenerator(n))
 	}
 }
 
-func (p *parser) value() Generator {
-	switch {
-	case p.found(ttExpression):
-		res, err := template.ExecuteInline(p.ds, fmt.Sprintf("{{%s}}", p.matched.val))
+// bound returns the integer value of the matched bound of a range.
+func (p *parser) bound() int64 {
+	var value Any
+	switch p.matched.typ {
+	case ttNumber:
+		v, err := parseNumber(p.matched.val)
 		if err != nil {
 			panic(err)
 		}
-		return mkValueGenerator(string(res))
+		value = v
+	case ttExpression:
+		value = p.eval(p.matched.val)
+	case ttVariable:
+		value = p.variable(p.matched.val).Get()
+	}
+
+	if p.dead {
+		return 0
+	}
+
+	switch v := reflect.ValueOf(value); v.Kind() {
+	case reflect.Int, reflect.Int8, reflect.Int16, reflect.Int32, reflect.Int64:
+		return v.Int()
+	case reflect.Uint, reflect.Uint8, reflect.Uint16, reflect.Uint32, reflect.Uint64:
+		return int64(v.Uint())
+	}
+
+	panic(fmt.Sprintf("the bounds of a range must be integers, not %v (%T)", value, value))
+}
+
+// appendValue parses the value of a '+=' assignment:
+// the elements of an array, or a single value, to append.
+func (p *parser) appendValue() Generator {
+	name := strings.Join(p.path, ".")
+	at := p.errorf("", false)
+
+	value := p.value()
+	arr, ok := value.(*arrayGenerator)
+	if !ok {
+		return mkArrayPatch(name, appendIndex, value, at)
+	}
+
+	res := &arrayPatch{name: name}
+	for _, el := range *arr {
+		res.edits = append(res.edits, arrayEdit{index: appendIndex, value: el, at: at})
+	}
+	return res
+}
+
+func (p *parser) value() Generator {
+	switch {
+	case p.found(ttExpression):
+		return mkValueGenerator(p.eval(p.matched.val))
+
+	case p.found(ttVariable):
+		return p.variable(p.matched.val)
 
 	case p.found(ttString):
+		if p.atInclude() {
+			return p.include()
+		}
 		return mkValueGenerator(p.matched.val)
 
 	case p.found(ttNil):
@@ -237,18 +647,43 @@ func (p *parser) value() Generator {
 	case p.found(ttLeftBracket):
 		return p.array()
 
+	case p.found(ttUnset):
+		return mkUnsetGenerator()
+
 	case p.found(ttEof):
-		panic("unexpected end of input")
+		panic(syntaxError("unexpected end of input"))
 
-	case p.found(ttError):
-		panic(p.matched.val)
+	case p.peek(ttIdentifier):
+		// a bare value on the next line (es. the next key)
+		panic(syntaxError("missing value"))
 
 	default:
-		p.advance()
-		panic("unexpected input")
+		panic(p.unexpected())
 	}
 }
 
+// eval runs the template expression against the data source
+// (and the visible variables) and returns its result keeping the native type.
+func (p *parser) eval(expr string) Any {
+	if p.dead {
+		return nil
+	}
+
+	ctx := template.Context{
+		Data: p.ds,
+		Vars: p.vars.all(),
+		Funcs: map[string]interface{}{
+			"ref": p.ref,
+		},
+	}
+
+	res, err := ctx.Evaluate(expr)
+	if err != nil {
+		panic(err)
+	}
+	return native(res)
+}
+
 func (p *parser) peek(tts ...tokenType) bool {
 	for _, v := range tts {
 		if p.next.typ == v {
@@ -270,13 +705,16 @@ func (p *parser) found(tts ...tokenType) bool {
 func (p *parser) expect(tts ...tokenType) error {
 	if !p.found(tts...) {
 		p.advance()
-		return fmt.Errorf("was expecting %v", tts)
+		return syntaxError(fmt.Sprintf("was expecting %s, found %s", tokenNames(tts), p.matched))
 	}
 	return nil
 }
 
 func (p *parser) advance() {
 	p.matched = p.next
+	if p.matched.typ == ttError {
+		panic(syntaxError(p.matched.val))
+	}
 	p.next = p.lexer.nextToken()
 }
 
@@ -299,14 +737,46 @@ func parseBool(value string) (Any, error) {
 	}
 }
 
+// parseNumber parses all the number literals accepted by the lexer
+// (es. 0x1F, 0o755, 0b101, 1_000, 1e3); integers become int64 (or uint64,
+// or *big.Int) and floats become float64 (or Decimal, if out of range);
+// imaginary numbers (es. 2.5i) become complex128.
 func parseNumber(value string) (Any, error) {
-	var v Any
-	v, err := strconv.ParseInt(value, 10, 64)
-	if err != nil {
-		v, err = strconv.ParseFloat(value, 64)
+	if v, ok := parseInt(value); ok {
+		return v, nil
 	}
-	if err != nil {
-		return nil, fmt.Errorf("invalid literal %q: is not a integer or a float number", value)
+	if v, ok := parseFloat(value); ok {
+		return v, nil
+	}
+	if strings.HasSuffix(value, "i") {
+		return parseComplex(value)
+	}
+	return nil, fmt.Errorf("invalid literal %q: is not a integer or a float number", value)
+}
+
+// native converts the maps decoded by yaml (map[interface{}]interface{})
+// into string keyed maps, so that both the encoders can handle them.
+func native(value Any) Any {
+	switch v := value.(type) {
+	case map[interface{}]interface{}:
+		res := make(map[string]interface{}, len(v))
+		for k, el := range v {
+			res[fmt.Sprintf("%v", k)] = native(el)
+		}
+		return res
+	case map[string]interface{}:
+		res := make(map[string]interface{}, len(v))
+		for k, el := range v {
+			res[k] = native(el)
+		}
+		return res
+	case []interface{}:
+		res := make([]interface{}, len(v))
+		for i, el := range v {
+			res[i] = native(el)
+		}
+		return res
+	default:
+		return value
 	}
-	return v, nil
 }
@@ -1,7 +1,10 @@
 package parser
 
 import (
+	"fmt"
+	"math"
 	"testing"
+	"time"
 
 	"github.com/stretchr/testify/require"
 )
@@ -83,13 +86,16 @@ func TestParseObjectGenerator(t *testing.T) {
 		{
 			input: `a={b=c d=2 e=true f= {g=8.8 i="l m @n"}}`,
 			expected: &ObjectGenerator{
+				keys: []string{"a"},
 				fields: map[string]Generator{
 					"a": &ObjectGenerator{
+						keys: []string{"b", "d", "e", "f"},
 						fields: map[string]Generator{
 							"b": mkValueGenerator("c"),
 							"d": mkValueGenerator(int64(2)),
 							"e": mkValueGenerator(true),
 							"f": &ObjectGenerator{
+								keys: []string{"g", "i"},
 								fields: map[string]Generator{
 									"g": mkValueGenerator(float64(8.8)),
 									"i": mkValueGenerator("l m @n"),
@@ -104,8 +110,10 @@ func TestParseObjectGenerator(t *testing.T) {
 		{
 			input: `a={ b = (snakecase "FirstName") }`,
 			expected: &ObjectGenerator{
+				keys: []string{"a"},
 				fields: map[string]Generator{
 					"a": &ObjectGenerator{
+						keys: []string{"b"},
 						fields: map[string]Generator{
 							"b": mkValueGenerator("first_name"),
 						},
@@ -117,16 +125,19 @@ func TestParseObjectGenerator(t *testing.T) {
 		{
 			input: `apiVersion=v1 kind=Secret metadata.name=mysecret type=Opaque data={username=(b64enc "USER") password=(b64enc "PASS")}`,
 			expected: &ObjectGenerator{
+				keys: []string{"apiVersion", "kind", "metadata", "type", "data"},
 				fields: map[string]Generator{
 					"apiVersion": mkValueGenerator("v1"),
 					"kind":       mkValueGenerator("Secret"),
 					"metadata": &ObjectGenerator{
+						keys: []string{"name"},
 						fields: map[string]Generator{
 							"name": mkValueGenerator("mysecret"),
 						},
 					},
 					"type": mkValueGenerator("Opaque"),
 					"data": &ObjectGenerator{
+						keys: []string{"username", "password"},
 						fields: map[string]Generator{
 							"username": mkValueGenerator("VVNFUg=="),
 							"password": mkValueGenerator("UEFTUw=="),
@@ -155,10 +166,13 @@ func TestParseDotObjectGenerator(t *testing.T) {
 		{
 			input: `a."b.b".c=d`,
 			expected: &ObjectGenerator{
+				keys: []string{"a"},
 				fields: map[string]Generator{
 					"a": &ObjectGenerator{
+						keys: []string{"b.b"},
 						fields: map[string]Generator{
 							"b.b": &ObjectGenerator{
+								keys: []string{"c"},
 								fields: map[string]Generator{
 									"c": mkValueGenerator("d"),
 								},
@@ -171,8 +185,10 @@ func TestParseDotObjectGenerator(t *testing.T) {
 		{
 			input: `parent.child1=value1 parent.child2=value2`,
 			expected: &ObjectGenerator{
+				keys: []string{"parent"},
 				fields: map[string]Generator{
 					"parent": &ObjectGenerator{
+						keys: []string{"child1", "child2"},
 						fields: map[string]Generator{
 							"child1": mkValueGenerator("value1"),
 							"child2": mkValueGenerator("value2"),
@@ -195,13 +211,16 @@ func TestParseDotObjectGenerator(t *testing.T) {
 
 func TestComplexParse(t *testing.T) {
 	expected := &ObjectGenerator{
+		keys: []string{"id", "score", "caller", "customer", "enabled"},
 		fields: map[string]Generator{
 			"id":      mkValueGenerator(int64(42)),
 			"enabled": mkValueGenerator(true),
 			"score":   mkValueGenerator(float64(8.171)),
 			"caller": &ObjectGenerator{
+				keys: []string{"gender"},
 				fields: map[string]Generator{
 					"gender": &ObjectGenerator{
+						keys: []string{"code"},
 						fields: map[string]Generator{
 							"code": mkValueGenerator("MTIz"),
 						},
@@ -209,10 +228,12 @@ func TestComplexParse(t *testing.T) {
 				},
 			},
 			"customer": &ObjectGenerator{
+				keys: []string{"name", "age", "address"},
 				fields: map[string]Generator{
 					"name": mkValueGenerator("Geralt of Rivia"),
 					"age":  mkValueGenerator(int64(86)),
 					"address": &ObjectGenerator{
+						keys: []string{"zip"},
 						fields: map[string]Generator{
 							"zip": mkValueGenerator("75018"),
 						},
@@ -230,6 +251,7 @@ func TestComplexParse(t *testing.T) {
 
 func TestArrayParse(t *testing.T) {
 	expected := &ObjectGenerator{
+		keys: []string{"tags"},
 		fields: map[string]Generator{
 			"tags": &arrayGenerator{
 				mkValueGenerator("d1"),
@@ -243,3 +265,1120 @@ func TestArrayParse(t *testing.T) {
 	require.NoError(t, err)
 	require.Equal(t, []Generator{expected}, ast)
 }
+
+func TestParseTypedExpression(t *testing.T) {
+	data := map[string]interface{}{
+		"labels": map[interface{}]interface{}{"app": "web"},
+	}
+
+	testCases := []struct {
+		input    string
+		expected Generator
+	}{
+		{
+			input:    `replicas=(incr 2)`,
+			expected: oneFieldObjGen("replicas", int64(3)),
+		},
+		{
+			input:    `ok=(regexMatch "dog$" "bulldog")`,
+			expected: oneFieldObjGen("ok", true),
+		},
+		{
+			input:    `tags=(split "," "a,b")`,
+			expected: oneFieldObjGen("tags", []string{"a", "b"}),
+		},
+		{
+			input:    `labels=(.labels)`,
+			expected: oneFieldObjGen("labels", map[string]interface{}{"app": "web"}),
+		},
+		{
+			input: `tags=[(incr 1) (hasPrefix "cat" "catch")]`,
+			expected: mkObjectGenerator().add("tags", &arrayGenerator{
+				mkValueGenerator(int64(2)),
+				mkValueGenerator(true),
+			}),
+		},
+	}
+
+	for _, cas := range testCases {
+		t.Logf("Testing input: %s", cas.input)
+
+		ast, err := ParseString(cas.input, data)
+
+		require.NoError(t, err)
+		require.Equal(t, []Generator{cas.expected}, ast)
+	}
+}
+
+func TestParseNestedExpression(t *testing.T) {
+	testCases := []struct {
+		input    string
+		expected Generator
+	}{
+		{
+			input:    `day=(dateInZone "2006-01-02" (toDate "2006-01-02" "2021-03-04") "UTC")`,
+			expected: oneFieldObjGen("day", "2021-03-04"),
+		},
+		{
+			input:    `name=(upper (trim (printf "%s " .app)))`,
+			expected: oneFieldObjGen("name", "WEB"),
+		},
+		{
+			input:    `msg=(printf "(%s)" .app)`,
+			expected: oneFieldObjGen("msg", "(web)"),
+		},
+		{
+			input: `tags=[(upper (trim " a ")) (lower "B")]`,
+			expected: mkObjectGenerator().add("tags", &arrayGenerator{
+				mkValueGenerator("A"),
+				mkValueGenerator("b"),
+			}),
+		},
+	}
+
+	data := map[string]interface{}{"app": "web"}
+	for _, cas := range testCases {
+		t.Logf("Testing input: %s", cas.input)
+
+		ast, err := ParseString(cas.input, data)
+
+		require.NoError(t, err)
+		require.Equal(t, []Generator{cas.expected}, ast)
+	}
+}
+
+func TestParseQuotedKeys(t *testing.T) {
+	testCases := []struct {
+		input    string
+		expected Generator
+	}{
+		{
+			input: `metadata.labels."app.kubernetes.io/name" = web`,
+			expected: mkObjectGenerator().add("metadata",
+				mkObjectGenerator().add("labels",
+					oneFieldObjGen("app.kubernetes.io/name", "web"))),
+		},
+		{
+			input: `annotations = { "prometheus.io/scrape"=true "prometheus.io/port"="9090" }`,
+			expected: mkObjectGenerator().add("annotations",
+				mkObjectGenerator().
+					add("prometheus.io/scrape", mkValueGenerator(true)).
+					add("prometheus.io/port", mkValueGenerator("9090"))),
+		},
+		{
+			input:    `"a.b" = c`,
+			expected: oneFieldObjGen("a.b", "c"),
+		},
+		{
+			input:    `ports.8080 = http`,
+			expected: mkObjectGenerator().add("ports", oneFieldObjGen("8080", "http")),
+		},
+		{
+			input:    `8080 = http`,
+			expected: oneFieldObjGen("8080", "http"),
+		},
+		{
+			input: `items = [ "a.b"=c "d" ]`,
+			expected: mkObjectGenerator().add("items", &arrayGenerator{
+				oneFieldObjGen("a.b", "c"),
+				mkValueGenerator("d"),
+			}),
+		},
+		{
+			input: `ports = [ 8080=http 443 8443={name=tls} ]`,
+			expected: mkObjectGenerator().add("ports", &arrayGenerator{
+				oneFieldObjGen("8080", "http"),
+				mkValueGenerator(int64(443)),
+				mkObjectGenerator().add("8443", oneFieldObjGen("name", "tls")),
+			}),
+		},
+	}
+
+	for _, cas := range testCases {
+		t.Logf("Testing input: %s", cas.input)
+
+		ast, err := ParseString(cas.input, nil)
+
+		require.NoError(t, err)
+		require.Equal(t, []Generator{cas.expected}, ast)
+	}
+}
+
+func TestParseBareScalars(t *testing.T) {
+	testCases := []struct {
+		input    string
+		expected Generator
+	}{
+		{
+			input:    `image=nginx:1.21`,
+			expected: oneFieldObjGen("image", "nginx:1.21"),
+		},
+		{
+			input:    `version=1.2.3`,
+			expected: oneFieldObjGen("version", "1.2.3"),
+		},
+		{
+			input:    `host=10.0.0.1`,
+			expected: oneFieldObjGen("host", "10.0.0.1"),
+		},
+		{
+			input:    `url=http://x/y`,
+			expected: oneFieldObjGen("url", "http://x/y"),
+		},
+		{
+			input:    `ratio=-1.5`,
+			expected: oneFieldObjGen("ratio", float64(-1.5)),
+		},
+		{
+			input:    `tag=2021e`,
+			expected: oneFieldObjGen("tag", "2021e"),
+		},
+		{
+			input:    `zip=01234`,
+			expected: oneFieldObjGen("zip", int64(1234)),
+		},
+		{
+			input: `spec={image=busybox:latest replicas=3}`,
+			expected: mkObjectGenerator().add("spec",
+				mkObjectGenerator().
+					add("image", mkValueGenerator("busybox:latest")).
+					add("replicas", mkValueGenerator(int64(3)))),
+		},
+	}
+
+	for _, cas := range testCases {
+		t.Logf("Testing input: %s", cas.input)
+
+		ast, err := ParseString(cas.input, nil)
+
+		require.NoError(t, err)
+		require.Equal(t, []Generator{cas.expected}, ast)
+	}
+
+	for _, input := range []string{`a == 1`, `a = += 1`, `a = ?=1`} {
+		_, err := ParseString(input, nil)
+		require.Error(t, err, input)
+		require.Contains(t, err.Error(), "unexpected input", input)
+	}
+
+	// a bare value must be on the line of its key
+	_, err := ParseString("a =\nb=1", nil)
+	require.EqualError(t, err, "parse error: 1:3: missing value\na =\n  ^")
+
+	// a comment is not a value
+	_, err = ParseString("color = #fff\nsize=10", nil)
+	require.EqualError(t, err, "parse error: 1:9: missing value: a comment follows the assignment (quote a value starting with '#')\ncolor = #fff\n        ^")
+
+	ast, err := ParseString("color = \"#fff\" # white\nsize=10", nil)
+	require.NoError(t, err)
+	require.Equal(t, []Generator{mkObjectGenerator().add("color", mkValueGenerator("#fff")).add("size", mkValueGenerator(int64(10)))}, ast)
+
+	_, err = ParseString("a = {\n  b =\n  c = web\n}\nd =", nil)
+	require.EqualError(t, err, "parse error: 2:5: missing value\n  b =\n    ^\nparse error: 5:4: unexpected end of input\nd =\n   ^")
+}
+
+func TestParseComments(t *testing.T) {
+	input := []string{
+		"# a Secret",
+		"apiVersion=v1 // core group",
+		"kind=Secret",
+		"/* metadata",
+		"   section */",
+		"metadata.name=mysecret # the name",
+	}
+
+	expected := mkObjectGenerator().
+		add("apiVersion", mkValueGenerator("v1")).
+		add("kind", mkValueGenerator("Secret")).
+		add("metadata", oneFieldObjGen("name", "mysecret"))
+
+	ast, err := ParseTextLines(input, nil)
+
+	require.NoError(t, err)
+	require.Equal(t, []Generator{expected}, ast)
+}
+
+func TestParseMultiLineStrings(t *testing.T) {
+	input := []string{
+		`kind=ConfigMap`,
+		`data."nginx.conf" = <<-EOF`,
+		`    server {`,
+		`      listen 80;`,
+		`    }`,
+		`    EOF`,
+		`data.motd = """`,
+		`Hello`,
+		`  World"""`,
+	}
+
+	expected := mkObjectGenerator().
+		add("kind", mkValueGenerator("ConfigMap")).
+		add("data", mkObjectGenerator().
+			add("nginx.conf", mkValueGenerator("server {\n  listen 80;\n}\n")).
+			add("motd", mkValueGenerator("Hello\n  World")))
+
+	ast, err := ParseTextLines(input, nil)
+
+	require.NoError(t, err)
+	require.Equal(t, []Generator{expected}, ast)
+}
+
+func TestParseEscapes(t *testing.T) {
+	testCases := []struct {
+		input    string
+		expected Generator
+	}{
+		{
+			input:    `a="a\tb"`,
+			expected: oneFieldObjGen("a", "a\tb"),
+		},
+		{
+			input:    `a="say \"hi\"\n"`,
+			expected: oneFieldObjGen("a", "say \"hi\"\n"),
+		},
+		{
+			input:    `a="\u00e9t\u00e9"`,
+			expected: oneFieldObjGen("a", "été"),
+		},
+		{
+			input:    `a='a\tb'`,
+			expected: oneFieldObjGen("a", `a\tb`),
+		},
+		{
+			input:    "a=`^\\d+$`",
+			expected: oneFieldObjGen("a", `^\d+$`),
+		},
+	}
+
+	for _, cas := range testCases {
+		t.Logf("Testing input: %s", cas.input)
+
+		ast, err := ParseString(cas.input, nil)
+
+		require.NoError(t, err)
+		require.Equal(t, []Generator{cas.expected}, ast)
+	}
+}
+
+func TestParseBadEscape(t *testing.T) {
+	_, err := ParseString(`a="ok" b="x\zy"`, nil)
+	require.Error(t, err)
+
+	perr, ok := err.(parseError)
+	require.True(t, ok)
+	require.Equal(t, 11, perr.pos)
+	require.Contains(t, perr.message, `invalid escape sequence '\z'`)
+}
+
+func TestParseErrorPosition(t *testing.T) {
+	testCases := []struct {
+		input    string
+		expected string
+	}{
+		{
+			input:    "a = 1\nb = {\n  c = 2 ]\n}\nd = 4",
+			expected: "parse error: 3:9: unexpected input\n  c = 2 ]\n        ^",
+		},
+		{
+			input:    "a = 1\n\tb.é.[x",
+			expected: "parse error: 2:6: was expecting identifier, string, number or expression, found \"[\"\n\tb.é.[x\n\t    ^",
+		},
+		{
+			input:    "a = {\n  b = 1\n",
+			expected: "parse error: 3:1: was expecting '}', found EOF\n\n^",
+		},
+	}
+
+	for _, cas := range testCases {
+		_, err := ParseString(cas.input, nil)
+		require.Error(t, err)
+		require.Equal(t, cas.expected, err.Error())
+	}
+}
+
+func TestParseErrorRecovery(t *testing.T) {
+	input := `metadata = {
+  labels = { app = web ]
+  name = web
+}
+ports = [80 443 }
+$x = ]
+a+b = 1
+if (true) { c = ] }
+for $i in (until 3) { d = ] }
+e = (fail "x")
+`
+	_, err := ParseString(input, nil)
+	require.Error(t, err)
+
+	list, ok := err.(ErrorList)
+	require.True(t, ok, err.Error())
+	require.True(t, list.Syntax())
+
+	positions := []string{}
+	for _, e := range list {
+		line, col, _ := e.(parseError).position()
+		positions = append(positions, fmt.Sprintf("%d:%d", line, col))
+	}
+	// the failing expression is not evaluated after the syntax errors
+	require.Equal(t, []string{"2:24", "5:17", "6:6", "7:1", "8:17", "9:27"}, positions)
+
+	brackets := []struct {
+		input     string
+		positions []string
+	}{
+		{`b = { c = ] d.e. = 1 } f.g. = 2`, []string{"1:11", "1:18", "1:29"}},
+		{`l = [ 1 } ]`, []string{"1:9"}},
+		{`l = [ 1 } 2 ] m = [ 3`, []string{"1:9", "1:22"}},
+		{`a = [ 1 { b = 2 ] c = 3`, []string{"1:17"}},
+		{`a = { x = 1 { b = 2 } y = }`, []string{"1:13", "1:27"}},
+	}
+	for _, cas := range brackets {
+		_, err := ParseString(cas.input, nil)
+		require.Error(t, err)
+
+		list, ok := err.(ErrorList)
+		if !ok {
+			list = ErrorList{err.(Error)}
+		}
+
+		positions := []string{}
+		for _, e := range list {
+			line, col, _ := e.(parseError).position()
+			positions = append(positions, fmt.Sprintf("%d:%d", line, col))
+		}
+		require.Equal(t, cas.positions, positions, cas.input)
+	}
+
+	testCases := []struct {
+		input  string
+		syntax bool
+	}{
+		{`a = ]`, true},
+		{`a = "x`, true},
+		{`a = 1 oops c = 2`, true},
+		{`a = 1 "oops" 42`, true},
+		{`a = 1 (.x)`, true},
+		{`a = (fail "x")`, false},
+		{`a = [1] a[2] = 3`, false},
+		{`a:int = "x"`, false},
+	}
+	for _, cas := range testCases {
+		_, err := ParseString(cas.input, nil)
+		require.Error(t, err)
+
+		perr, ok := err.(Error)
+		require.True(t, ok)
+		require.Equal(t, cas.syntax, perr.Syntax(), cas.input)
+	}
+}
+
+func TestParseMultiDocument(t *testing.T) {
+	testCases := []struct {
+		input    string
+		expected []Generator
+	}{
+		{
+			input:    ``,
+			expected: []Generator{mkObjectGenerator()},
+		},
+		{
+			input:    `{a=1} {b=2}`,
+			expected: []Generator{oneFieldObjGen("a", int64(1)), oneFieldObjGen("b", int64(2))},
+		},
+		{
+			input:    "a=1\n---\nb=2",
+			expected: []Generator{oneFieldObjGen("a", int64(1)), oneFieldObjGen("b", int64(2))},
+		},
+		{
+			input:    "---\na=1\n---\n{b=2}\n---\n",
+			expected: []Generator{oneFieldObjGen("a", int64(1)), oneFieldObjGen("b", int64(2))},
+		},
+	}
+
+	for _, cas := range testCases {
+		t.Logf("Testing input: %s", cas.input)
+
+		ast, err := ParseString(cas.input, nil)
+
+		require.NoError(t, err)
+		require.Equal(t, cas.expected, ast)
+	}
+}
+
+func TestParseVariables(t *testing.T) {
+	testCases := []struct {
+		input    string
+		expected []Generator
+	}{
+		{
+			input: `$app=web $tag="1.21" metadata.name=$app image=(printf "%s:%s" $app $tag)`,
+			expected: []Generator{mkObjectGenerator().
+				add("metadata", oneFieldObjGen("name", "web")).
+				add("image", mkValueGenerator("web:1.21"))},
+		},
+		{
+			input: `$labels={app=web tier=front} metadata.labels=$labels selector=(index $labels "tier")`,
+			expected: []Generator{mkObjectGenerator().
+				add("metadata", mkObjectGenerator().add("labels", mkObjectGenerator().
+					add("app", mkValueGenerator("web")).
+					add("tier", mkValueGenerator("front")))).
+				add("selector", mkValueGenerator("front"))},
+		},
+		{
+			input: `$ns=prod a={ $ns=dev ns=$ns } b.ns=$ns tags=[$ns (upper $ns)]`,
+			expected: []Generator{mkObjectGenerator().
+				add("a", oneFieldObjGen("ns", "dev")).
+				add("b", oneFieldObjGen("ns", "prod")).
+				add("tags", &arrayGenerator{
+					mkValueGenerator("prod"),
+					mkValueGenerator("PROD"),
+				})},
+		},
+		{
+			input: `$app=web {name=$app} {name=(upper $app)}`,
+			expected: []Generator{
+				oneFieldObjGen("name", "web"),
+				oneFieldObjGen("name", "WEB"),
+			},
+		},
+	}
+
+	for _, cas := range testCases {
+		t.Logf("Testing input: %s", cas.input)
+
+		ast, err := ParseString(cas.input, nil)
+
+		require.NoError(t, err)
+		require.Equal(t, cas.expected, ast)
+	}
+}
+
+func TestParseUndefinedVariable(t *testing.T) {
+	_, err := ParseString(`a={ $x=1 } b=$x`, nil)
+	require.Error(t, err)
+	require.Contains(t, err.Error(), "undefined variable $x")
+}
+
+func TestParseReferences(t *testing.T) {
+	testCases := []struct {
+		input    string
+		expected Generator
+	}{
+		{
+			input: `metadata.name=web spec.selector.matchLabels.app=(ref "metadata.name")`,
+			expected: mkObjectGenerator().
+				add("metadata", oneFieldObjGen("name", "web")).
+				add("spec", mkObjectGenerator().add("selector",
+					mkObjectGenerator().add("matchLabels", oneFieldObjGen("app", "web")))),
+		},
+		{
+			input: `spec={replicas=2 max=(ref "spec.replicas" | incr)}`,
+			expected: mkObjectGenerator().add("spec", mkObjectGenerator().
+				add("replicas", mkValueGenerator(int64(2))).
+				add("max", mkValueGenerator(int64(3)))),
+		},
+		{
+			input: `labels={app=web} copy=(ref "labels") tier=(ref "labels" | len)`,
+			expected: mkObjectGenerator().
+				add("labels", oneFieldObjGen("app", "web")).
+				add("copy", mkValueGenerator(map[string]interface{}{"app": "web"})).
+				add("tier", mkValueGenerator(1)),
+		},
+		{
+			input: "ports=[{port=80} {port=(ref `ports.0.port`)}] x.\"a.b\"=1 c=(ref `x.\"a.b\"`)",
+			expected: mkObjectGenerator().
+				add("ports", &arrayGenerator{
+					oneFieldObjGen("port", int64(80)),
+					oneFieldObjGen("port", int64(80)),
+				}).
+				add("x", oneFieldObjGen("a.b", int64(1))).
+				add("c", mkValueGenerator(int64(1))),
+		},
+	}
+
+	for _, cas := range testCases {
+		t.Logf("Testing input: %s", cas.input)
+
+		ast, err := ParseString(cas.input, nil)
+
+		require.NoError(t, err)
+		require.Equal(t, []Generator{cas.expected}, ast)
+	}
+}
+
+func TestParseBadReferences(t *testing.T) {
+	testCases := []struct {
+		input   string
+		message string
+	}{
+		{
+			input:   `a=(ref "b") b=1`,
+			message: `undefined reference "b"`,
+		},
+		{
+			input:   `a=(ref "a")`,
+			message: `circular reference: "a" refers to "a"`,
+		},
+		{
+			input:   `spec={name=(ref "spec")}`,
+			message: `circular reference: "spec.name" refers to "spec"`,
+		},
+		{
+			input:   `a=(ref "x..y")`,
+			message: `invalid reference "x..y"`,
+		},
+	}
+
+	for _, cas := range testCases {
+		_, err := ParseString(cas.input, nil)
+		require.Error(t, err)
+		require.Contains(t, err.Error(), cas.message)
+	}
+}
+
+func TestParseArrayPatches(t *testing.T) {
+	testCases := []struct {
+		input    string
+		expected Any
+	}{
+		{
+			input: `containers=[{name=web image=nginx:1.20}] containers[0].image=nginx:1.21 containers[+].name=sidecar`,
+			expected: Object{{Key: "containers", Value: []Any{
+				Object{{Key: "name", Value: "web"}, {Key: "image", Value: "nginx:1.21"}},
+				Object{{Key: "name", Value: "sidecar"}},
+			}}},
+		},
+		{
+			input:    `tags=[a b c] tags+=[d e] tags += f tags[1]=B`,
+			expected: Object{{Key: "tags", Value: []Any{"a", "B", "c", "d", "e", "f"}}},
+		},
+		{
+			input: `spec.template.spec.containers=[{name=web}] spec.template.spec.containers[0].ports[+].containerPort=80`,
+			expected: Object{{Key: "spec", Value: Object{{Key: "template", Value: Object{{Key: "spec", Value: Object{
+				{Key: "containers", Value: []Any{Object{
+					{Key: "name", Value: "web"},
+					{Key: "ports", Value: []Any{Object{{Key: "containerPort", Value: int64(80)}}}},
+				}}},
+			}}}}}}},
+		},
+		{
+			input:    `s=(seq 3) s[0]=9 s[+]=4`,
+			expected: Object{{Key: "s", Value: []Any{int64(9), int64(2), int64(3), int64(4)}}},
+		},
+		{
+			input:    `matrix=[[1 2] [3 4]] matrix[1][0]=5 items[+]=x items[0]=y`,
+			expected: Object{{Key: "matrix", Value: []Any{[]Any{int64(1), int64(2)}, []Any{int64(5), int64(4)}}}, {Key: "items", Value: []Any{"y"}}},
+		},
+		{
+			input:    `tags=(.tags) tags+=[c]`,
+			expected: Object{{Key: "tags", Value: []Any{"a", "b", "c"}}},
+		},
+	}
+
+	data := map[string]interface{}{"tags": []interface{}{"a", "b"}}
+	for _, cas := range testCases {
+		t.Logf("Testing input: %s", cas.input)
+
+		ast, err := ParseString(cas.input, data)
+
+		require.NoError(t, err)
+		require.Len(t, ast, 1)
+		require.Equal(t, cas.expected, ast[0].Get())
+	}
+}
+
+func TestParseBadArrayPatches(t *testing.T) {
+	testCases := []struct {
+		input   string
+		message string
+	}{
+		{
+			input:   `tags=[a] tags[3]=b`,
+			message: `tags[3]: index out of range (the array has 1 elements)`,
+		},
+		{
+			input:   `spec.containers[0].image=nginx`,
+			message: `spec.containers[0]: index out of range (the array has 0 elements)`,
+		},
+		{
+			input:   `tags[x]=b`,
+			message: `bad array index "x"`,
+		},
+		{
+			input:   "a[3]=1\nb=2\nc=3",
+			message: "1:2: a[3]: index out of range (the array has 0 elements)\na[3]=1\n ^",
+		},
+		{
+			input:   `a=1 a[0]=2`,
+			message: "1:6: a is not an array",
+		},
+		{
+			input:   `a={b=1} a+=[2]`,
+			message: "1:10: a is not an array",
+		},
+	}
+
+	for _, cas := range testCases {
+		_, err := ParseString(cas.input, nil)
+		require.Error(t, err)
+		require.Contains(t, err.Error(), cas.message)
+	}
+}
+
+func TestParseUnset(t *testing.T) {
+	testCases := []struct {
+		input    string
+		expected Any
+	}{
+		{
+			input: `metadata={name=web annotations={foo=1 bar=2}} -metadata.annotations.foo`,
+			expected: Object{{Key: "metadata", Value: Object{
+				{Key: "name", Value: "web"},
+				{Key: "annotations", Value: Object{{Key: "bar", Value: int64(2)}}},
+			}}},
+		},
+		{
+			input:    `a=1 b=2 "c d"=3 b=!unset -"c d"`,
+			expected: Object{{Key: "a", Value: int64(1)}},
+		},
+		{
+			input:    `a=1 -a a=2 -b -c.d`,
+			expected: Object{{Key: "a", Value: int64(2)}},
+		},
+		{
+			input:    `a={b=1 c=2} a={-b d=3}`,
+			expected: Object{{Key: "a", Value: Object{{Key: "c", Value: int64(2)}, {Key: "d", Value: int64(3)}}}},
+		},
+		{
+			input:    `a="!unset" b=[c] -b.c`,
+			expected: Object{{Key: "a", Value: "!unset"}, {Key: "b", Value: []Any{"c"}}},
+		},
+	}
+
+	for _, cas := range testCases {
+		t.Logf("Testing input: %s", cas.input)
+
+		ast, err := ParseString(cas.input, nil)
+
+		require.NoError(t, err)
+		require.Len(t, ast, 1)
+		require.Equal(t, cas.expected, ast[0].Get())
+	}
+}
+
+func TestParseConditionals(t *testing.T) {
+	testCases := []struct {
+		input    string
+		expected Any
+	}{
+		{
+			input: `name=web if (eq .env "prod") { replicas=3 } else { replicas=1 debug=true } port=80`,
+			expected: Object{
+				{Key: "name", Value: "web"},
+				{Key: "replicas", Value: int64(3)},
+				{Key: "port", Value: int64(80)},
+			},
+		},
+		{
+			input:    `if (eq .env "dev") { a=1 } else if (eq .env "prod") { a=2 } else if (true) { a=3 } else { a=4 }`,
+			expected: Object{{Key: "a", Value: int64(2)}},
+		},
+		{
+			input:    `metadata={ name=web if (.labels) { labels=(.labels) } }`,
+			expected: Object{{Key: "metadata", Value: Object{{Key: "name", Value: "web"}}}},
+		},
+		{
+			input:    `ports=[80 if (eq .env "prod") { 443 {name=tls} } if (.missing) { 8443 } 8080]`,
+			expected: Object{{Key: "ports", Value: []Any{int64(80), int64(443), Object{{Key: "name", Value: "tls"}}, int64(8080)}}},
+		},
+		{
+			// the dead blocks are not evaluated
+			input:    `if (.missing) { $x=(fail "boom") a=(ref "nope") b=$x } c=1`,
+			expected: Object{{Key: "c", Value: int64(1)}},
+		},
+		{
+			input:    `if (true) { if (false) { a=1 } else { a=2 } } if=3 else=4`,
+			expected: Object{{Key: "a", Value: int64(2)}, {Key: "if", Value: int64(3)}, {Key: "else", Value: int64(4)}},
+		},
+	}
+
+	data := map[string]interface{}{"env": "prod"}
+	for _, cas := range testCases {
+		t.Logf("Testing input: %s", cas.input)
+
+		ast, err := ParseString(cas.input, data)
+
+		require.NoError(t, err)
+		require.Len(t, ast, 1)
+		require.Equal(t, cas.expected, ast[0].Get())
+	}
+
+	for _, input := range []string{`if (true) a=1`, `if (true) { a=1 `, `a=[if (true) { 1 ]`} {
+		_, err := ParseString(input, data)
+		require.Error(t, err, input)
+	}
+}
+
+func TestParseLoops(t *testing.T) {
+	testCases := []struct {
+		input    string
+		expected Any
+	}{
+		{
+			input: `containers=[for $s in (.services) { name=($s.name) port=($s.port) }]`,
+			expected: Object{{Key: "containers", Value: []Any{
+				Object{{Key: "name", Value: "web"}, {Key: "port", Value: 80}},
+				Object{{Key: "name", Value: "api"}, {Key: "port", Value: 8080}},
+			}}},
+		},
+		{
+			input:    `ports=[22 for $i, $s in (.services) if (gt $i 0) { ($s.port) }]`,
+			expected: Object{{Key: "ports", Value: []Any{int64(22), 8080}}},
+		},
+		{
+			input:    `for $x in (.services) { last=($x.name) } for $k, $v in (.labels) { tags+=[(printf "%s=%s" $k $v)] }`,
+			expected: Object{{Key: "last", Value: "api"}, {Key: "tags", Value: []Any{"app=shop", "tier=front"}}},
+		},
+		{
+			input:    `m=[for $i in (2) [for $j in (2) (printf "%d%d" $i $j)]]`,
+			expected: Object{{Key: "m", Value: []Any{[]Any{"00", "01"}, []Any{"10", "11"}}}},
+		},
+		{
+			// the body of an empty loop is not evaluated
+			input:    `a=[for $s in (.missing) (fail "boom")] for=1`,
+			expected: Object{{Key: "a", Value: []Any{}}, {Key: "for", Value: int64(1)}},
+		},
+	}
+
+	data := map[string]interface{}{
+		"services": []interface{}{
+			map[string]interface{}{"name": "web", "port": 80},
+			map[string]interface{}{"name": "api", "port": 8080},
+		},
+		"labels": map[string]interface{}{"tier": "front", "app": "shop"},
+	}
+	for _, cas := range testCases {
+		t.Logf("Testing input: %s", cas.input)
+
+		ast, err := ParseString(cas.input, data)
+
+		require.NoError(t, err)
+		require.Len(t, ast, 1)
+		require.Equal(t, cas.expected, ast[0].Get())
+	}
+
+	for _, input := range []string{`a=[for $s (.services) $s]`, `for $s in (.services) a=1`, `a=[for $s in ("abc") $s]`} {
+		_, err := ParseString(input, data)
+		require.Error(t, err, input)
+	}
+
+	_, err := ParseString(`for my-item in (.services) { name=(my-item.name) }`, data)
+	require.EqualError(t, err, "parse error: 1:5: was expecting a loop variable (es. $item), found \"my-item\"\nfor my-item in (.services) { name=(my-item.name) }\n    ^")
+
+	_, err = ParseString(`a=[for $i, x in (.services) $i]`, data)
+	require.EqualError(t, err, "parse error: 1:12: was expecting a loop variable (es. $item), found \"x\"\na=[for $i, x in (.services) $i]\n           ^")
+}
+
+func TestParseFile(t *testing.T) {
+	ast, err := ParseFile("testdata/deployment.yo", map[string]interface{}{"tier": "front"})
+	require.NoError(t, err)
+	require.Len(t, ast, 1)
+
+	expected := Object{
+		{Key: "resources", Value: Object{{Key: "limits", Value: Object{
+			{Key: "cpu", Value: "500m"},
+			{Key: "memory", Value: "128Mi"},
+		}}}},
+		{Key: "metadata", Value: Object{
+			{Key: "name", Value: "web"},
+			{Key: "labels", Value: Object{
+				{Key: "app", Value: "web"},
+				{Key: "tier", Value: "front"},
+				{Key: "extra", Value: "yes"},
+			}},
+		}},
+	}
+	require.Equal(t, expected, ast[0].Get())
+
+	ast, err = ParseString(`labels=[include("testdata/common/labels.yo")]`, nil)
+	require.NoError(t, err)
+	require.Equal(t, Object{{Key: "labels", Value: []Any{Object{{Key: "app", Value: "web"}, {Key: "tier", Value: nil}}}}}, ast[0].Get())
+}
+
+func TestParseFileErrors(t *testing.T) {
+	testCases := []struct {
+		path    string
+		message string
+	}{
+		{"testdata/cycle_a.yo", "testdata/cycle_b.yo:2:12: import cycle: cycle_a.yo -> cycle_b.yo -> cycle_a.yo"},
+		{"testdata/imports_broken.yo", "testdata/broken.yo:3:11: unexpected input"},
+		{"testdata/missing.yo", "no such file or directory"},
+	}
+
+	for _, cas := range testCases {
+		_, err := ParseFile(cas.path, nil)
+		require.Error(t, err)
+		require.Contains(t, err.Error(), cas.message)
+	}
+}
+
+func TestParseSpread(t *testing.T) {
+	testCases := []struct {
+		input    string
+		expected Any
+	}{
+		{
+			input: `metadata.labels = { ...(.labels) extra=1 nested.b=3 }`,
+			expected: Object{{Key: "metadata", Value: Object{{Key: "labels", Value: Object{
+				{Key: "app", Value: "web"},
+				{Key: "nested", Value: Object{{Key: "a", Value: 1}, {Key: "b", Value: int64(3)}}},
+				{Key: "extra", Value: int64(1)},
+			}}}}},
+		},
+		{
+			input:    `app=db ...(.labels) ...(.missing)`,
+			expected: Object{{Key: "app", Value: "web"}, {Key: "nested", Value: Object{{Key: "a", Value: 1}, {Key: "b", Value: 2}}}},
+		},
+		{
+			input:    `$base={a=1 b={c=2}} x={...$base b.d=3}`,
+			expected: Object{{Key: "x", Value: Object{{Key: "a", Value: int64(1)}, {Key: "b", Value: Object{{Key: "c", Value: int64(2)}, {Key: "d", Value: int64(3)}}}}}},
+		},
+		{
+			input:    `ids=[0 ...(seq 3)]`,
+			expected: Object{{Key: "ids", Value: []Any{int64(0), int64(1), int64(2), int64(3)}}},
+		},
+		{
+			input:    `ports=[22 ...(.ports) 443]`,
+			expected: Object{{Key: "ports", Value: []Any{int64(22), 80, 8080, int64(443)}}},
+		},
+	}
+
+	data := map[string]interface{}{
+		"labels": map[string]interface{}{
+			"nested": map[string]interface{}{"b": 2, "a": 1},
+			"app":    "web",
+		},
+		"ports": []interface{}{80, 8080},
+	}
+	for _, cas := range testCases {
+		t.Logf("Testing input: %s", cas.input)
+
+		ast, err := ParseString(cas.input, data)
+
+		require.NoError(t, err)
+		require.Len(t, ast, 1)
+		require.Equal(t, cas.expected, ast[0].Get())
+	}
+
+	for _, input := range []string{`a={...(.ports)}`, `a=[...(.labels)]`, `a={... x=1}`} {
+		_, err := ParseString(input, data)
+		require.Error(t, err, input)
+	}
+}
+
+func TestParseComputedKeys(t *testing.T) {
+	testCases := []struct {
+		input    string
+		expected Any
+	}{
+		{
+			input: `(printf "%s-config" .app) = { data.(.filename) = "x=1" }`,
+			expected: Object{{Key: "web-config", Value: Object{{Key: "data", Value: Object{
+				{Key: "app.conf", Value: "x=1"},
+			}}}}},
+		},
+		{
+			input: `for $e in (.envs) { ($e).replicas = 1 }`,
+			expected: Object{
+				{Key: "dev", Value: Object{{Key: "replicas", Value: int64(1)}}},
+				{Key: "prod", Value: Object{{Key: "replicas", Value: int64(1)}}},
+			},
+		},
+		{
+			input:    `a={(.app)=1 b=2} -a.(.app) list=[(.app)=3] (.port)=4`,
+			expected: Object{{Key: "a", Value: Object{{Key: "b", Value: int64(2)}}}, {Key: "list", Value: []Any{Object{{Key: "web", Value: int64(3)}}}}, {Key: "8080", Value: int64(4)}},
+		},
+	}
+
+	data := map[string]interface{}{
+		"app":      "web",
+		"filename": "app.conf",
+		"envs":     []interface{}{"dev", "prod"},
+		"port":     8080,
+	}
+	for _, cas := range testCases {
+		t.Logf("Testing input: %s", cas.input)
+
+		ast, err := ParseString(cas.input, data)
+
+		require.NoError(t, err)
+		require.Len(t, ast, 1)
+		require.Equal(t, cas.expected, ast[0].Get())
+	}
+
+	testErrors := []struct {
+		input   string
+		message string
+	}{
+		{`(.missing)=1`, "the computed key (.missing) is null"},
+		{`(.envs)=1`, "the computed key (.envs) must be a string, not []interface {}"},
+		{`a=1 (.app)`, "unexpected input"},
+	}
+	for _, cas := range testErrors {
+		_, err := ParseString(cas.input, data)
+		require.Error(t, err)
+		require.Contains(t, err.Error(), cas.message)
+	}
+}
+
+func TestParseDefaultAndOverride(t *testing.T) {
+	testCases := []struct {
+		input    string
+		expected Any
+	}{
+		{
+			input:    `replicas=3 replicas?=1 image?=nginx image?=httpd`,
+			expected: Object{{Key: "replicas", Value: int64(3)}, {Key: "image", Value: "nginx"}},
+		},
+		{
+			input: `limits.cpu:=1 limits={cpu=2 memory=1Gi} limits.cpu?=3`,
+			expected: Object{{Key: "limits", Value: Object{
+				{Key: "cpu", Value: int64(1)},
+				{Key: "memory", Value: "1Gi"},
+			}}},
+		},
+		{
+			input:    `spec={a=1} spec:={b=2} spec.c=3 spec:={d=4}`,
+			expected: Object{{Key: "spec", Value: Object{{Key: "d", Value: int64(4)}}}},
+		},
+		{
+			input:    `tags?=[a] tags+=[b] x=1 -x x?=2`,
+			expected: Object{{Key: "tags", Value: []Any{"a", "b"}}, {Key: "x", Value: int64(2)}},
+		},
+		{
+			input:    `list=[{a=1}] list[0].a?=2 list[0].b?=3`,
+			expected: Object{{Key: "list", Value: []Any{Object{{Key: "a", Value: int64(1)}, {Key: "b", Value: int64(3)}}}}},
+		},
+	}
+
+	for _, cas := range testCases {
+		t.Logf("Testing input: %s", cas.input)
+
+		ast, err := ParseString(cas.input, nil)
+
+		require.NoError(t, err)
+		require.Len(t, ast, 1)
+		require.Equal(t, cas.expected, ast[0].Get())
+	}
+}
+
+func TestParseTypeAnnotations(t *testing.T) {
+	input := `port:int = "8080" version:string = 1.10 created:timestamp = "2021-01-01"
+		mode:int = "0o644" zip:int = "08080" ratio:float = "0.5" debug:bool = "true" size:uint = (.size)
+		replicas:int ?= "2" replicas:int ?= "3" name:string := 42
+		min:int = -9223372036854775808 max:uint = 18446744073709551615 big:int = 1e18`
+
+	ast, err := ParseString(input, map[string]interface{}{"size": "1024"})
+	require.NoError(t, err)
+	require.Len(t, ast, 1)
+
+	expected := Object{
+		{Key: "port", Value: int64(8080)},
+		{Key: "version", Value: "1.10"},
+		{Key: "created", Value: time.Date(2021, 1, 1, 0, 0, 0, 0, time.UTC)},
+		{Key: "mode", Value: int64(0644)},
+		{Key: "zip", Value: int64(8080)},
+		{Key: "ratio", Value: 0.5},
+		{Key: "debug", Value: true},
+		{Key: "size", Value: uint64(1024)},
+		{Key: "replicas", Value: int64(2)},
+		{Key: "name", Value: "42"},
+		{Key: "min", Value: int64(math.MinInt64)},
+		{Key: "max", Value: uint64(math.MaxUint64)},
+		{Key: "big", Value: int64(1e18)},
+	}
+	require.Equal(t, expected, ast[0].Get())
+
+	testErrors := []struct {
+		input   string
+		message string
+	}{
+		{`port:int = "http"`, `port: unable to cast "http" to int`},
+		{`a.b:int = 1.5`, "a.b: unable to cast 1.5 to int without losing its fractional part"},
+		{`a:bool = maybe`, `a: unable to cast "maybe" to bool`},
+		{`a:uint = -1`, "a: unable to cast negative value"},
+		{`a:timestamp = "yesterday"`, "a: unable to parse date: yesterday"},
+		{`a:int = [1]`, "a: only a scalar value can be converted to int"},
+		{`a:number = 1`, `unknown type "number"`},
+		{`a:int += [1]`, "a type annotation must be followed by"},
+		{`x:int = 1e30`, "x: unable to cast 1e+30 to int: out of range"},
+		{`x:int = 18446744073709551615`, "x: unable to cast 18446744073709551615 to int: out of range"},
+		{`x:int = "9223372036854775808"`, `x: unable to cast "9223372036854775808" to int: out of range`},
+		{`x:uint = 1e30`, "x: unable to cast 1e+30 to uint: out of range"},
+		{`x:uint = 123456789012345678901234567890`, "x: unable to cast 123456789012345678901234567890 to uint: out of range"},
+		{`x:uint = -123456789012345678901234567890`, "x: unable to cast negative value -123456789012345678901234567890 to uint"},
+		{`x:int = 1e400`, "x: unable to cast 1e+400 to int: out of range"},
+		{`x:int = (.missing)`, "x: unable to cast null to int"},
+		{`x:uint = (.missing)`, "x: unable to cast null to uint"},
+		{`x:float = 123456789012345678901234567890`, "x: unable to cast 123456789012345678901234567890 to float"},
+	}
+	for _, cas := range testErrors {
+		_, err := ParseString(cas.input, nil)
+		require.Error(t, err)
+		require.Contains(t, err.Error(), cas.message)
+	}
+}
+
+func TestParseRanges(t *testing.T) {
+	testCases := []struct {
+		input    string
+		expected []Any
+	}{
+		{`a=[1..5]`, []Any{int64(1), int64(2), int64(3), int64(4), int64(5)}},
+		{`a=[0..20 step 5]`, []Any{int64(0), int64(5), int64(10), int64(15), int64(20)}},
+		{`a=[3..1]`, []Any{int64(3), int64(2), int64(1)}},
+		{`a=[10..0 step -5]`, []Any{int64(10), int64(5), int64(0)}},
+		{`a=[0 8080..8081 (.n)..(incr .n)]`, []Any{int64(0), int64(8080), int64(8081), int64(3), int64(4)}},
+		{`$x=2 a=[$x..(.n) step $x]`, []Any{int64(2)}},
+		{`a=[1..2 "step"]`, []Any{int64(1), int64(2), "step"}},
+		{`a=(seq 2 4)`, []Any{[]int64{2, 3, 4}}},
+		{`a=[for $i in (until 2) $i]`, []Any{int64(0), int64(1)}},
+	}
+
+	data := map[string]interface{}{"n": 3}
+	for _, cas := range testCases {
+		t.Logf("Testing input: %s", cas.input)
+
+		ast, err := ParseString(cas.input, data)
+		require.NoError(t, err)
+		require.Len(t, ast, 1)
+
+		value := ast[0].Get().(Object)[0].Value
+		if arr, ok := value.([]Any); ok {
+			require.Equal(t, cas.expected, arr)
+		} else {
+			require.Equal(t, cas.expected, []Any{value})
+		}
+	}
+
+	testErrors := []struct {
+		input   string
+		message string
+	}{
+		{`a=[1..5 step -1]`, "the step -1 never goes from 1 to 5"},
+		{`a=[1..5 step 0]`, "the step of a sequence cannot be zero"},
+		{`a=[1.5..3]`, "the bounds of a range must be integers"},
+		{`a=[1..]`, "was expecting"},
+	}
+	for _, cas := range testErrors {
+		_, err := ParseString(cas.input, data)
+		require.Error(t, err)
+		require.Contains(t, err.Error(), cas.message)
+	}
+
+	for _, input := range []string{`a=[1..3 step]`, `a=[1..3 step x]`} {
+		_, err := ParseString(input, data)
+		require.EqualError(t, err, fmt.Sprintf("parse error: 1:9: missing the step of the range (a number, an expression or a variable)\n%s\n        ^", input))
+	}
+}
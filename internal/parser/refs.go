@@ -0,0 +1,141 @@
+package parser
+
+import (
+	"fmt"
+	"strconv"
+	"strings"
+)
+
+// frame is an object (or array) still being parsed,
+// with the path where it is going to be placed.
+type frame struct {
+	path []string
+	gen  Generator
+}
+
+// enter appends key to the path of the field being parsed.
+func (p *parser) enter(key string) {
+	p.path = append(p.path, key)
+}
+
+// leave removes the last key from the path of the field being parsed.
+func (p *parser) leave() {
+	p.path = p.path[:len(p.path)-1]
+}
+
+func (p *parser) pushFrame(gen Generator) {
+	path := make([]string, len(p.path))
+	copy(path, p.path)
+	p.frames = append(p.frames, frame{path: path, gen: gen})
+}
+
+func (p *parser) popFrame() {
+	p.frames = p.frames[:len(p.frames)-1]
+}
+
+// ref returns the value of an already defined field of the document
+// being built; name is a dotted path where the keys with special
+// chars are quoted (es. `metadata.labels."app.kubernetes.io/name"`).
+func (p *parser) ref(name string) (interface{}, error) {
+	path, err := splitPath(name)
+	if err != nil {
+		return nil, err
+	}
+
+	if len(p.path) > 0 && hasPrefix(p.path, path) {
+		return nil, fmt.Errorf("circular reference: %q refers to %q", strings.Join(p.path, "."), name)
+	}
+
+	for i := len(p.frames) - 1; i >= 0; i-- {
+		f := p.frames[i]
+		if !hasPrefix(path, f.path) {
+			continue
+		}
+
+		if v, ok := lookup(f.gen.Get(), path[len(f.path):]); ok {
+			return plain(v), nil
+		}
+	}
+
+	return nil, fmt.Errorf("undefined reference %q: a field can only refer to the fields defined before it", name)
+}
+
+// splitPath splits a dotted path in its keys.
+func splitPath(name string) ([]string, error) {
+	res := []string{}
+
+	lex := newLexer(name)
+	for {
+		tok := lex.nextToken()
+		switch tok.typ {
+		case ttIdentifier, ttString, ttNumber:
+			res = append(res, tok.val)
+		default:
+			return nil, fmt.Errorf("invalid reference %q", name)
+		}
+
+		switch tok = lex.nextToken(); tok.typ {
+		case ttDot:
+		case ttEof:
+			return res, nil
+		default:
+			return nil, fmt.Errorf("invalid reference %q", name)
+		}
+	}
+}
+
+// lookup walks the value v following path.
+func lookup(v Any, path []string) (Any, bool) {
+	for _, key := range path {
+		switch vt := v.(type) {
+		case Object:
+			found := false
+			for _, f := range vt {
+				if f.Key == key {
+					v, found = f.Value, true
+					break
+				}
+			}
+			if !found {
+				return nil, false
+			}
+		case map[string]interface{}:
+			el, ok := vt[key]
+			if !ok {
+				return nil, false
+			}
+			v = el
+		case []Any:
+			idx, err := strconv.Atoi(key)
+			if err != nil || idx < 0 || idx >= len(vt) {
+				return nil, false
+			}
+			v = vt[idx]
+		case []interface{}:
+			idx, err := strconv.Atoi(key)
+			if err != nil || idx < 0 || idx >= len(vt) {
+				return nil, false
+			}
+			v = vt[idx]
+		default:
+			return nil, false
+		}
+	}
+
+	return v, true
+}
+
+// hasPrefix reports whether path begins with prefix.
+func hasPrefix(path, prefix []string) bool {
+	if len(prefix) > len(path) {
+		return false
+	}
+
+	for i, key := range prefix {
+		if path[i] != key {
+			return false
+		}
+	}
+
+	return true
+}
@@ -26,7 +26,7 @@ func ParseString(input string, data map[string]interface{}) ([]Generator, error)
 // ParseTextLines parse a slice of lines.
 // Returns either a slice of Generators on success or else an error.
 func ParseTextLines(lines []string, data map[string]interface{}) ([]Generator, error) {
-	spec := strings.Join(lines, " ")
+	spec := strings.Join(lines, "\n")
 	return ParseString(spec, data)
 }
 
@@ -41,8 +41,7 @@ func ParseReader(reader io.Reader, data map[string]interface{}) ([]Generator, er
 	res := []string{}
 
 	for scanner.Scan() {
-		line := strings.TrimSpace(scanner.Text())
-		res = append(res, line)
+		res = append(res, scanner.Text())
 	}
 
 	if err := scanner.Err(); err != nil {
@@ -0,0 +1,42 @@
+package parser
+
+// scope holds the variables declared in a block ($name = value);
+// inner scopes can read the variables of the outer ones.
+type scope struct {
+	vars   map[string]Any
+	parent *scope
+}
+
+func newScope(parent *scope) *scope {
+	return &scope{
+		vars:   map[string]Any{},
+		parent: parent,
+	}
+}
+
+// lookup returns the value of the variable name searching
+// from the innermost to the outermost scope.
+func (s *scope) lookup(name string) (Any, bool) {
+	for cur := s; cur != nil; cur = cur.parent {
+		if v, ok := cur.vars[name]; ok {
+			return v, true
+		}
+	}
+	return nil, false
+}
+
+func (s *scope) set(name string, value Any) {
+	s.vars[name] = value
+}
+
+// all returns all the visible variables, as plain Go values.
+func (s *scope) all() map[string]interface{} {
+	res := map[string]interface{}{}
+	if s.parent != nil {
+		res = s.parent.all()
+	}
+	for k, v := range s.vars {
+		res[k] = plain(v)
+	}
+	return res
+}
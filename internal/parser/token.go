@@ -1,6 +1,9 @@
 package parser
 
-import "fmt"
+import (
+	"fmt"
+	"strings"
+)
 
 // tokenType identifies the type of lex tokens.
 type tokenType int
@@ -8,9 +11,11 @@ type tokenType int
 const (
 	ttEof tokenType = -1
 
-	ttError   tokenType = iota // error occurred; value is text of error
-	ttComplex                  // complex constant (1+2i); imaginary is just a number
-	ttAssign                   // equals ('=') introducing an assignment
+	ttError    tokenType = iota // error occurred; value is text of error
+	ttComplex                   // complex constant (1+2i); imaginary is just a number
+	ttAssign                    // equals ('=') introducing an assignment
+	ttDefault                   // '?=' assignment of an undefined key only
+	ttOverride                  // ':=' assignment that cannot be changed
 
 	ttIdentifier   // alphanumeric identifier
 	ttLeftBrace    // '{' object begin
@@ -22,14 +27,87 @@ const (
 	ttNumber     // simple number, including imaginary
 	ttString     // string (without quotes)
 	ttExpression // template expression (without round brackets)
+	ttSeparator  // '---' documents separator
+	ttVariable   // variable name (without the '$')
+	ttAppend     // '+=' appending to an array
+	ttIndex      // array index of a key ('[0]' or '[+]'), without brackets
+	ttComma      // ',' separating the loop variables
+	ttSpread     // '...' spreading an object (or an array) into another one
+	ttRange      // '..' between the bounds of a range (es. [1..5])
+	ttColon      // ':' before the type of a field (es. port:int)
 
 	// Keywords appear after all the rest.
 	ttKeyword // used only to delimit the keywords
 	ttBool    // boolean constant (true or false)
 	ttDot     // the cursor, spelled '.'
 	ttNil     // the untyped nil constant, easiest to treat as a keyword
+	ttUnset   // removes a key: '-' before the key or '!unset' as value
 )
 
+// isAssign reports whether typ is one of the assignment operators.
+func (typ tokenType) isAssign() bool {
+	switch typ {
+	case ttAssign, ttAppend, ttDefault, ttOverride:
+		return true
+	}
+	return false
+}
+
+// Make the types prettyprint.
+var tokenName = map[tokenType]string{
+	ttEof:   "end of input",
+	ttError: "error",
+
+	ttComplex:      "complex",
+	ttAssign:       "'='",
+	ttDefault:      "'?='",
+	ttOverride:     "':='",
+	ttIdentifier:   "identifier",
+	ttLeftBrace:    "'{'",
+	ttRightBrace:   "'}'",
+	ttLeftBracket:  "'['",
+	ttRightBracket: "']'",
+	ttNumber:       "number",
+	ttString:       "string",
+	ttExpression:   "expression",
+	ttSeparator:    "'---'",
+	ttVariable:     "variable",
+	ttAppend:       "'+='",
+	ttIndex:        "index",
+	ttComma:        "','",
+	ttSpread:       "'...'",
+	ttRange:        "'..'",
+	ttColon:        "':'",
+
+	// keywords
+	ttBool:  "bool",
+	ttDot:   "'.'",
+	ttNil:   "null",
+	ttUnset: "unset",
+}
+
+func (tt tokenType) String() string {
+	s := tokenName[tt]
+	if s == "" {
+		return fmt.Sprintf("token%d", int(tt))
+	}
+
+	return s
+}
+
+// tokenNames returns the names of tts (es. "'=', '.' or index").
+func tokenNames(tts []tokenType) string {
+	names := make([]string, len(tts))
+	for i, tt := range tts {
+		names[i] = tt.String()
+	}
+	if len(names) < 2 {
+		return strings.Join(names, "")
+	}
+
+	return strings.Join(names[:len(names)-1], ", ") + " or " + names[len(names)-1]
+}
+
 // item represents a token or text string returned from the scanner.
 type token struct {
 	typ  tokenType // The type of this item.
@@ -58,4 +136,6 @@ var key = map[string]tokenType{
 	"false": ttBool,
 	"null":  ttNil,
 	"nil":   ttNil,
+
+	"!unset": ttUnset,
 }
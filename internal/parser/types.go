@@ -0,0 +1,176 @@
+package parser
+
+import (
+	"fmt"
+	"math"
+	"math/big"
+	"reflect"
+	"strings"
+
+	"github.com/lucasepe/yo/internal/cast"
+)
+
+// conversion converts the value of a field to the annotated type.
+type conversion func(Any) (Any, error)
+
+// conversions are the types of the annotations (es. port:int = "8080").
+var conversions = map[string]conversion{
+	"bool":      toBool,
+	"float":     toFloat,
+	"int":       toInt,
+	"string":    toString,
+	"timestamp": toTimestamp,
+	"uint":      toUint,
+}
+
+func toBool(v Any) (Any, error) {
+	res, err := cast.ToBoolE(v)
+	if err != nil {
+		return nil, fmt.Errorf("unable to cast %s to bool", literal(v))
+	}
+	return res, nil
+}
+
+func toFloat(v Any) (Any, error) {
+	res, err := cast.ToFloat64E(v)
+	if err != nil {
+		return nil, fmt.Errorf("unable to cast %s to float", literal(v))
+	}
+	return res, nil
+}
+
+func toString(v Any) (Any, error) {
+	return cast.ToStringE(v)
+}
+
+func toTimestamp(v Any) (Any, error) {
+	return cast.ToTimeE(v)
+}
+
+// toInt converts v to int64; the strings are number literals
+// and the floats must not have a fractional part.
+func toInt(v Any) (Any, error) {
+	n, err := integer(v, "int")
+	if err != nil {
+		return nil, err
+	}
+	if !n.IsInt64() {
+		return nil, fmt.Errorf("unable to cast %s to int: out of range", literal(v))
+	}
+	return n.Int64(), nil
+}
+
+// toUint converts v to uint64 (see toInt).
+func toUint(v Any) (Any, error) {
+	n, err := integer(v, "uint")
+	if err != nil {
+		return nil, err
+	}
+	if n.Sign() < 0 {
+		return nil, fmt.Errorf("unable to cast negative value %s to uint", literal(v))
+	}
+	if !n.IsUint64() {
+		return nil, fmt.Errorf("unable to cast %s to uint: out of range", literal(v))
+	}
+	return n.Uint64(), nil
+}
+
+// integer returns the exact value of v, to check that it is in
+// the range of typ (int or uint) before the conversion.
+func integer(v Any, typ string) (*big.Int, error) {
+	switch vt := v.(type) {
+	case nil:
+		return nil, fmt.Errorf("unable to cast null to %s", typ)
+	case string:
+		if res, ok := parseInt(strings.TrimSpace(vt)); ok {
+			return integer(res, typ)
+		}
+		return nil, fmt.Errorf("unable to cast %s to %s", literal(vt), typ)
+	case bool:
+		if vt {
+			return big.NewInt(1), nil
+		}
+		return big.NewInt(0), nil
+	case *big.Int:
+		return vt, nil
+	case Decimal:
+		if !vt.IsInt() {
+			return nil, fmt.Errorf("unable to cast %v to %s without losing its fractional part", vt, typ)
+		}
+		res, _ := vt.Int(nil)
+		return res, nil
+	}
+
+	switch val := reflect.ValueOf(v); val.Kind() {
+	case reflect.Int, reflect.Int8, reflect.Int16, reflect.Int32, reflect.Int64:
+		return big.NewInt(val.Int()), nil
+	case reflect.Uint, reflect.Uint8, reflect.Uint16, reflect.Uint32, reflect.Uint64, reflect.Uintptr:
+		return new(big.Int).SetUint64(val.Uint()), nil
+	case reflect.Float32, reflect.Float64:
+		f := val.Float()
+		if math.IsInf(f, 0) || math.IsNaN(f) {
+			return nil, fmt.Errorf("unable to cast %v to %s", f, typ)
+		}
+		if f != math.Trunc(f) {
+			return nil, fmt.Errorf("unable to cast %v to %s without losing its fractional part", f, typ)
+		}
+		res, _ := big.NewFloat(f).Int(nil)
+		return res, nil
+	}
+
+	return nil, fmt.Errorf("unable to cast %s (%T) to %s", literal(v), v, typ)
+}
+
+// literal returns v as it is written in the errors: the
+// strings are quoted, the other values (es. *big.Int) are not.
+func literal(v Any) string {
+	if s, ok := v.(string); ok {
+		return fmt.Sprintf("%q", s)
+	}
+	return fmt.Sprintf("%v", v)
+}
+
+// annotation parses the optional ':type' after a field key
+// and returns the name of the type ("" if there is none).
+func (p *parser) annotation() string {
+	if !p.found(ttColon) {
+		return ""
+	}
+
+	if err := p.expect(ttIdentifier); err != nil {
+		panic(err)
+	}
+
+	if _, ok := conversions[p.matched.val]; !ok {
+		panic(syntaxError(fmt.Sprintf("unknown type %q (must be bool, float, int, string, timestamp or uint)", p.matched.val)))
+	}
+
+	return p.matched.val
+}
+
+// typed converts the value just parsed to the type typ; a number
+// (or a bool) converted to string keeps its literal text (es. 1.10).
+func (p *parser) typed(typ string, gen Generator) Generator {
+	if p.dead {
+		return gen
+	}
+
+	if typ == "string" {
+		switch p.matched.typ {
+		case ttNumber, ttComplex, ttBool:
+			return mkValueGenerator(p.matched.val)
+		}
+	}
+
+	field := strings.Join(p.path, ".")
+	switch v := gen.Get().(type) {
+	case Object, []Any:
+		panic(fmt.Sprintf("%s: only a scalar value can be converted to %s", field, typ))
+	default:
+		res, err := conversions[typ](v)
+		if err != nil {
+			panic(fmt.Sprintf("%s: %v", field, err))
+		}
+		return mkValueGenerator(res)
+	}
+}
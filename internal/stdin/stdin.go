@@ -36,10 +36,10 @@ func Input() string {
 			break
 		}
 
-		lines = append(lines, strings.TrimSpace(ln))
+		lines = append(lines, ln)
 	}
 
-	return strings.Join(lines, " ")
+	return strings.Join(lines, "\n")
 }
 
 func autoCompleter() *readline.PrefixCompleter {
@@ -243,6 +243,16 @@ var functionsMap = map[string]builtinFunction{
 		summary: "Generate a list with all of the duplicates removed.",
 		usage:   `split "$" "foo$bar$baz$bar" | uniq`,
 	},
+	"seq": {
+		fn:      seq,
+		summary: "Generate a list of integers from start (default 1) to end (inclusive), by step (default 1 or -1).",
+		usage:   `seq 0 20 5`,
+	},
+	"until": {
+		fn:      until,
+		summary: "Generate a list of integers from 0 to n (exclusive).",
+		usage:   `until 5`,
+	},
 }
 
 // Names returns the builtin functions names.
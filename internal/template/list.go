@@ -58,3 +58,71 @@ func inList(haystack []interface{}, needle interface{}) bool {
 	}
 	return false
 }
+
+// maxSequence is the maximum number of elements of a sequence.
+const maxSequence = 1000000
+
+// Sequence returns the integers from start to end (inclusive)
+// by step; step must be not zero and agree with the direction.
+func Sequence(start, end, step int64) ([]int64, error) {
+	switch {
+	case step == 0:
+		return nil, fmt.Errorf("the step of a sequence cannot be zero")
+	case start < end && step < 0, start > end && step > 0:
+		return nil, fmt.Errorf("the step %d never goes from %d to %d", step, start, end)
+	}
+
+	// the distance and the stride as uint64 never overflow
+	// (es. from math.MinInt64 to math.MaxInt64)
+	span, stride := uint64(end)-uint64(start), uint64(step)
+	if start > end {
+		span, stride = uint64(start)-uint64(end), -stride
+	}
+
+	if span/stride >= maxSequence {
+		return nil, fmt.Errorf("the sequence from %d to %d has too many elements (max %d)", start, end, maxSequence)
+	}
+
+	n := int64(span/stride) + 1
+	res := make([]int64, 0, n)
+	for i := int64(0); i < n; i++ {
+		res = append(res, start+i*step)
+	}
+	return res, nil
+}
+
+// seq is like the unix command: seq END, seq START END or seq START END STEP.
+func seq(args ...interface{}) ([]int64, error) {
+	start, step := int64(1), int64(0)
+
+	var end int64
+	switch len(args) {
+	case 1:
+		end = toInt64(args[0])
+	case 2:
+		start, end = toInt64(args[0]), toInt64(args[1])
+	case 3:
+		start, end, step = toInt64(args[0]), toInt64(args[1]), toInt64(args[2])
+	default:
+		return nil, fmt.Errorf("seq: wrong number of args: want 1, 2 or 3 got %d", len(args))
+	}
+
+	if step == 0 {
+		step = 1
+		if start > end {
+			step = -1
+		}
+	}
+
+	return Sequence(start, end, step)
+}
+
+// until returns the integers from 0 to n (exclusive).
+func until(n interface{}) ([]int64, error) {
+	end := toInt64(n)
+	if end <= 0 {
+		return []int64{}, nil
+	}
+
+	return Sequence(0, end-1, 1)
+}
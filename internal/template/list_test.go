@@ -1,6 +1,7 @@
 package template
 
 import (
+	"math"
 	"testing"
 
 	"github.com/stretchr/testify/assert"
@@ -35,3 +36,43 @@ func TestHas(t *testing.T) {
 		assert.NoError(t, runtv(tpl, expect, vars))
 	}
 }
+
+func TestSeq(t *testing.T) {
+	tests := map[string]string{
+		`{{ seq 3 }}`:           `[1 2 3]`,
+		`{{ seq 2 4 }}`:         `[2 3 4]`,
+		`{{ seq 0 20 5 }}`:      `[0 5 10 15 20]`,
+		`{{ seq 3 1 }}`:         `[3 2 1]`,
+		`{{ seq 10 0 -4 }}`:     `[10 6 2]`,
+		`{{ until 3 }}`:         `[0 1 2]`,
+		`{{ until 0 }}`:         `[]`,
+		`{{ len (seq 1 1) }}`:   `1`,
+		`{{ index (seq 7) 6 }}`: `7`,
+	}
+	for tpl, expect := range tests {
+		assert.NoError(t, runt(tpl, expect))
+	}
+
+	_, err := Sequence(1, 5, -1)
+	assert.Error(t, err)
+
+	_, err = Sequence(1, 5, 0)
+	assert.Error(t, err)
+
+	_, err = seq()
+	assert.Error(t, err)
+
+	_, err = Sequence(math.MinInt64, math.MaxInt64, 1)
+	assert.Error(t, err)
+
+	_, err = Sequence(0, math.MaxInt64, 2)
+	assert.Error(t, err)
+
+	res, err := Sequence(math.MaxInt64, math.MinInt64, math.MinInt64)
+	assert.NoError(t, err)
+	assert.Equal(t, []int64{math.MaxInt64, -1}, res)
+
+	res, err = Sequence(math.MinInt64, math.MaxInt64, math.MaxInt64)
+	assert.NoError(t, err)
+	assert.Equal(t, []int64{math.MinInt64, -1, math.MaxInt64 - 1}, res)
+}
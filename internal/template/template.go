@@ -1,26 +1,94 @@
 package template
 
 import (
-	"bytes"
+	"fmt"
+	"io/ioutil"
+	"sort"
+	"strings"
 	"text/template"
 )
 
-func ExecuteInline(data interface{}, s string) ([]byte, error) {
+const (
+	// captureFunc is the name of the function used by Evaluate
+	// to grab the result of the evaluated pipeline.
+	captureFunc = "__yo_capture"
+	// varFunc is the name of the function used to read
+	// the value of the variables declared in the template.
+	varFunc = "__yo_var"
+)
+
+// Context is what an expression can see
+// besides the builtin functions.
+type Context struct {
+	// Data is the dot ('.') of the template.
+	Data interface{}
+	// Vars are available in the template as $name.
+	Vars map[string]interface{}
+	// Funcs are additional functions (they can hide the builtin ones).
+	Funcs template.FuncMap
+}
+
+// Evaluate runs the pipeline s (without delimiters) and returns
+// its result keeping the native Go type (int64, bool, time.Time,
+// slices, maps...) instead of its text representation.
+func (c Context) Evaluate(s string) (interface{}, error) {
+	var res interface{}
+
 	// Build function map.
 	funcMap := TxtFuncMap()
+	funcMap[captureFunc] = func(v interface{}) string {
+		res = v
+		return ""
+	}
 
-	// Build the template
-	t := template.New("main")
-	t.Funcs(funcMap)
-
-	_, err := t.Parse(s)
+	t, err := c.parse(fmt.Sprintf("{{%s (%s)}}", captureFunc, s), funcMap)
 	if err != nil {
 		return nil, err
 	}
 
-	var buf bytes.Buffer
-	if err := t.Execute(&buf, data); err != nil {
+	if err := t.Execute(ioutil.Discard, c.Data); err != nil {
 		return nil, err
 	}
-	return buf.Bytes(), nil
+	return res, nil
+}
+
+// Evaluate runs the pipeline s against data keeping the native
+// type of its result; vars are available in the pipeline as $name.
+func Evaluate(data interface{}, vars map[string]interface{}, s string) (interface{}, error) {
+	return Context{Data: data, Vars: vars}.Evaluate(s)
+}
+
+// IsTrue reports whether v is true in the sense of the template
+// if action: false, 0, nil, empty strings, slices and maps are false.
+func IsTrue(v interface{}) bool {
+	truth, _ := template.IsTrue(v)
+	return truth
+}
+
+// parse builds the template s declaring, before it, all the vars.
+func (c Context) parse(s string, funcMap template.FuncMap) (*template.Template, error) {
+	names := make([]string, 0, len(c.Vars))
+	for k := range c.Vars {
+		names = append(names, k)
+	}
+	sort.Strings(names)
+
+	var sb strings.Builder
+	for _, name := range names {
+		fmt.Fprintf(&sb, "{{$%s := %s %q}}", name, varFunc, name)
+	}
+	sb.WriteString(s)
+
+	for k, fn := range c.Funcs {
+		funcMap[k] = fn
+	}
+	funcMap[varFunc] = func(name string) interface{} {
+		return c.Vars[name]
+	}
+
+	// Build the template
+	t := template.New("main")
+	t.Funcs(funcMap)
+
+	return t.Parse(sb.String())
 }
@@ -0,0 +1,73 @@
+package template
+
+import (
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestEvaluate(t *testing.T) {
+	testCases := []struct {
+		expr     string
+		expected interface{}
+	}{
+		{`incr 2`, int64(3)},
+		{`regexMatch "dog$" "bulldog"`, true},
+		{`split "," "a,b"`, []string{"a", "b"}},
+		{`upper "hello"`, "HELLO"},
+		{`.name`, "yo"},
+		{`.missing`, nil},
+	}
+
+	data := map[string]interface{}{"name": "yo"}
+	for _, cas := range testCases {
+		res, err := Evaluate(data, nil, cas.expr)
+		assert.NoError(t, err)
+		assert.Equal(t, cas.expected, res)
+	}
+
+	res, err := Evaluate(nil, nil, `toDate "2006-01-02" "2017-12-31"`)
+	assert.NoError(t, err)
+	assert.IsType(t, time.Time{}, res)
+
+	_, err = Evaluate(nil, nil, `notAFunction 1`)
+	assert.Error(t, err)
+}
+
+func TestEvaluateVars(t *testing.T) {
+	vars := map[string]interface{}{
+		"app":    "web",
+		"labels": map[string]interface{}{"tier": "front"},
+	}
+
+	res, err := Evaluate(nil, vars, `printf "%s-%s" $app $labels.tier`)
+	assert.NoError(t, err)
+	assert.Equal(t, "web-front", res)
+
+	_, err = Evaluate(nil, vars, `upper $missing`)
+	assert.Error(t, err)
+}
+
+func TestContextFuncs(t *testing.T) {
+	ctx := Context{
+		Data: map[string]interface{}{"name": "yo"},
+		Funcs: map[string]interface{}{
+			"twice": func(s string) string { return s + s },
+		},
+	}
+
+	res, err := ctx.Evaluate(`twice .name | upper`)
+	assert.NoError(t, err)
+	assert.Equal(t, "YOYO", res)
+}
+
+func TestIsTrue(t *testing.T) {
+	for _, v := range []interface{}{true, 1, "a", []string{"a"}, map[string]int{"a": 1}} {
+		assert.True(t, IsTrue(v), "%#v", v)
+	}
+
+	for _, v := range []interface{}{false, 0, "", []string{}, map[string]int{}, nil} {
+		assert.False(t, IsTrue(v), "%#v", v)
+	}
+}
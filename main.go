@@ -11,6 +11,6 @@ func main() {
 	app := cmd.Run()
 	if err := app.Execute(); err != nil {
 		fmt.Fprintf(os.Stderr, "mkobj error: %s\n", err.Error())
-		os.Exit(1)
+		os.Exit(cmd.ExitCode(err))
 	}
 }